@@ -1,13 +1,19 @@
 package couchdb
 
 import (
+	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha1"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Auth is implemented by HTTP authentication mechanisms.
@@ -17,6 +23,24 @@ type Auth interface {
 	AddAuth(*http.Request)
 }
 
+// requestAuth is implemented by Auth mechanisms that need to contact the
+// server, or that may fail, while authenticating a request. The transport
+// uses it instead of AddAuth.
+type requestAuth interface {
+	authRequest(ctx context.Context, t *transport, req *http.Request) error
+}
+
+// sessionAuth is implemented by Auth mechanisms that keep a session with
+// the server.
+type sessionAuth interface {
+	// observe inspects a response for renewed session credentials.
+	observe(resp *http.Response)
+	// renew is called after the server rejected a request authenticated
+	// by the Auth with 401 Unauthorized. It reports whether the request
+	// should be sent once more with fresh credentials.
+	renew(req *http.Request) bool
+}
+
 type basicauth string
 
 // BasicAuth returns an Auth that performs HTTP Basic Authentication.
@@ -56,3 +80,127 @@ func (a proxyauth) AddAuth(req *http.Request) {
 		req.Header.Set("X-Auth-CouchDB-Token", a.tok)
 	}
 }
+
+const sessionCookieName = "AuthSession"
+
+type cookieauth struct {
+	username, password string
+
+	mu      sync.Mutex
+	cookie  *http.Cookie
+	renewAt time.Time // zero if the session has no known expiry
+}
+
+// CookieAuth returns an Auth that performs CouchDB cookie authentication.
+// The first request logs in via POST /_session and the AuthSession cookie
+// is sent with every following request. The session is renewed before it
+// expires and, if the server rejects it with 401 Unauthorized anyway, the
+// Auth logs in again and the request is sent once more.
+//
+// A CookieAuth keeps the session of a single server, it should not be
+// shared between clients of different servers.
+//
+// http://docs.couchdb.org/en/latest/api/server/authn.html#cookie-authentication
+func CookieAuth(username, password string) Auth {
+	return &cookieauth{username: username, password: password}
+}
+
+// AddAuth adds the current session cookie, if there is one. It never
+// logs in, the transport takes care of that.
+func (a *cookieauth) AddAuth(req *http.Request) {
+	a.mu.Lock()
+	c := a.cookie
+	a.mu.Unlock()
+	if c != nil {
+		req.AddCookie(&http.Cookie{Name: c.Name, Value: c.Value})
+	}
+}
+
+func (a *cookieauth) authRequest(ctx context.Context, t *transport, req *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cookie == nil || (!a.renewAt.IsZero() && !time.Now().Before(a.renewAt)) {
+		if err := a.login(ctx, t); err != nil {
+			return err
+		}
+	}
+	req.AddCookie(&http.Cookie{Name: a.cookie.Name, Value: a.cookie.Value})
+	return nil
+}
+
+// login opens a new session. It must be called with a.mu held.
+func (a *cookieauth) login(ctx context.Context, t *transport) error {
+	creds, err := json.Marshal(struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}{a.username, a.password})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", t.prefix+"/_session", bytes.NewReader(creds))
+	if err != nil {
+		return err
+	}
+	if ctx != context.Background() {
+		req = req.WithContext(ctx)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.roundTrip(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return parseError(resp)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if !a.update(resp) || a.cookie == nil {
+		return fmt.Errorf("couchdb: missing %s cookie in /_session response", sessionCookieName)
+	}
+	return nil
+}
+
+// update stores the session cookie set by resp, if any. It must be called
+// with a.mu held.
+func (a *cookieauth) update(resp *http.Response) bool {
+	for _, c := range resp.Cookies() {
+		if c.Name != sessionCookieName {
+			continue
+		}
+		if c.Value == "" || c.MaxAge < 0 {
+			a.cookie, a.renewAt = nil, time.Time{}
+			return true
+		}
+		var lifetime time.Duration
+		if c.MaxAge > 0 {
+			lifetime = time.Duration(c.MaxAge) * time.Second
+		} else if !c.Expires.IsZero() {
+			lifetime = time.Until(c.Expires)
+		}
+		a.cookie, a.renewAt = c, time.Time{}
+		if lifetime > 0 {
+			// Renew once four fifths of the session lifetime have passed.
+			a.renewAt = time.Now().Add(lifetime * 4 / 5)
+		}
+		return true
+	}
+	return false
+}
+
+// observe picks up the session cookies that CouchDB sends when it
+// refreshes a session on its own.
+func (a *cookieauth) observe(resp *http.Response) {
+	a.mu.Lock()
+	a.update(resp)
+	a.mu.Unlock()
+}
+
+func (a *cookieauth) renew(req *http.Request) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if sent, err := req.Cookie(sessionCookieName); err == nil && a.cookie != nil && sent.Value == a.cookie.Value {
+		// Only drop the session if no other request renewed it meanwhile.
+		a.cookie, a.renewAt = nil, time.Time{}
+	}
+	return true
+}
@@ -1,6 +1,9 @@
 package couchdb_test
 
 import (
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"testing"
 
@@ -49,3 +52,78 @@ func TestProxyAuthWithToken(t *testing.T) {
 	}
 	check(t, "req headers", expected, req.Header)
 }
+
+func TestCookieAuth(t *testing.T) {
+	c := newTestClient(t)
+	logins := 0
+	c.Handle("POST /_session", func(resp http.ResponseWriter, req *http.Request) {
+		logins++
+		body, _ := ioutil.ReadAll(req.Body)
+		check(t, "login body", `{"name":"user","password":"password"}`, string(body))
+		http.SetCookie(resp, &http.Cookie{Name: "AuthSession", Value: "session1", MaxAge: 600})
+		io.WriteString(resp, `{"ok":true,"name":"user","roles":[]}`)
+	})
+	c.Handle("GET /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		cookie, err := req.Cookie("AuthSession")
+		if err != nil {
+			t.Fatal("missing session cookie")
+		}
+		if cookie.Value == "session1" {
+			// CouchDB refreshes the session on its own
+			http.SetCookie(resp, &http.Cookie{Name: "AuthSession", Value: "session2", MaxAge: 600})
+		} else {
+			check(t, "refreshed cookie", "session2", cookie.Value)
+		}
+		io.WriteString(resp, `{"_id":"doc","field":1}`)
+	})
+
+	c.SetAuth(couchdb.CookieAuth("user", "password"))
+	var doc testDocument
+	for i := 0; i < 3; i++ {
+		if err := c.DB("db").Get("doc", &doc, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(t, "logins", 1, logins)
+}
+
+func TestCookieAuthRenewsRejectedSession(t *testing.T) {
+	c := newTestClient(t)
+	logins := 0
+	c.Handle("POST /_session", func(resp http.ResponseWriter, req *http.Request) {
+		logins++
+		http.SetCookie(resp, &http.Cookie{Name: "AuthSession", Value: fmt.Sprint("session", logins)})
+		io.WriteString(resp, `{"ok":true}`)
+	})
+	c.Handle("PUT /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		check(t, "request body", `{"field":999}`, string(body))
+		if cookie, _ := req.Cookie("AuthSession"); cookie.Value == "session1" {
+			resp.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(resp, `{"error":"unauthorized","reason":"session expired"}`)
+			return
+		}
+		resp.Header().Set("ETag", `"1-619db7ba8551c0de3f3a178775509611"`)
+		resp.WriteHeader(http.StatusCreated)
+	})
+
+	c.SetAuth(couchdb.CookieAuth("user", "password"))
+	rev, err := c.DB("db").Put("doc", &testDocument{Field: 999}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "rev", "1-619db7ba8551c0de3f3a178775509611", rev)
+	check(t, "logins", 2, logins)
+}
+
+func TestCookieAuthLoginError(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("POST /_session", func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(resp, `{"error":"unauthorized","reason":"Name or password is incorrect."}`)
+	})
+
+	c.SetAuth(couchdb.CookieAuth("user", "wrong"))
+	err := c.Ping()
+	check(t, "couchdb.Unauthorized(err)", true, couchdb.Unauthorized(err))
+}
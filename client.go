@@ -50,7 +50,7 @@ func (c *Client) URL() string {
 // Ping can be used to check whether a server is alive.
 // It sends an HTTP HEAD request to the server's URL.
 func (c *Client) Ping() error {
-	_, err := c.closedRequest(c.ctx, "HEAD", "/", nil)
+	_, err := c.closedRequest(c.opctx("Ping", ""), "HEAD", "/", nil)
 	return err
 }
 
@@ -67,7 +67,7 @@ func (c *Client) SetAuth(a Auth) {
 // already exists. A valid DB object is returned in all cases, even if the
 // request fails.
 func (c *Client) CreateDB(name string) (*DB, error) {
-	if _, err := c.closedRequest(c.ctx, "PUT", path(name), nil); err != nil {
+	if _, err := c.closedRequest(c.opctx("CreateDB", name), "PUT", path(name), nil); err != nil {
 		return c.DB(name), err
 	}
 	return c.DB(name), nil
@@ -75,7 +75,7 @@ func (c *Client) CreateDB(name string) (*DB, error) {
 
 // CreateDBWithShards creates a new database with the specified number of shards
 func (c *Client) CreateDBWithShards(name string, shards int) (*DB, error) {
-	_, err := c.closedRequest(c.ctx, "PUT", fmt.Sprintf("%s?q=%d", path(name), shards), nil)
+	_, err := c.closedRequest(c.opctx("CreateDBWithShards", name), "PUT", fmt.Sprintf("%s?q=%d", path(name), shards), nil)
 
 	return c.DB(name), err
 }
@@ -91,13 +91,13 @@ func (c *Client) EnsureDB(name string) (*DB, error) {
 
 // DeleteDB deletes an existing database.
 func (c *Client) DeleteDB(name string) error {
-	_, err := c.closedRequest(c.ctx, "DELETE", path(name), nil)
+	_, err := c.closedRequest(c.opctx("DeleteDB", name), "DELETE", path(name), nil)
 	return err
 }
 
 // AllDBs returns the names of all existing databases.
 func (c *Client) AllDBs() (names []string, err error) {
-	resp, err := c.request(c.ctx, "GET", "/_all_dbs", nil)
+	resp, err := c.request(c.opctx("AllDBs", ""), "GET", "/_all_dbs", nil)
 	if err != nil {
 		return names, err
 	}
@@ -384,6 +384,18 @@ func TestBulkDocs(t *testing.T) {
 	check(t, "updateFailuteRes.Error", "conflict", updateFailuteRes.Error)
 }
 
+func TestBulkDocsRequestError(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("POST /db/_bulk_docs", func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(rw, `{"error":"internal_server_error","reason":"failed"}`)
+	})
+
+	res, err := c.DB("db").BulkDocs(testDocument{ID: "a"})
+	check(t, "res", []couchdb.BulkDocsResp(nil), res)
+	check(t, "status", true, couchdb.ErrorStatus(err, http.StatusInternalServerError))
+}
+
 func TestPutWithRev(t *testing.T) {
 	c := newTestClient(t)
 	c.Handle("PUT /db/doc", func(resp http.ResponseWriter, req *http.Request) {
@@ -0,0 +1,293 @@
+package couchdb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Balancing selects the node of a cluster client a request is sent to.
+type Balancing int
+
+const (
+	// RoundRobin sends requests to the nodes in turn.
+	RoundRobin Balancing = iota
+	// LeastLoaded sends requests to the node with the fewest requests
+	// in flight. A request is in flight until its response body is
+	// closed, so open feeds and row iterators count.
+	LeastLoaded
+)
+
+// ClusterOptions configures a client created by NewClusterClient.
+// The zero value uses the defaults noted on each field.
+type ClusterOptions struct {
+	// Balancing defaults to RoundRobin.
+	Balancing Balancing
+
+	// FailureThreshold is the number of consecutive connection failures
+	// after which a node is marked down. Defaults to 1.
+	FailureThreshold int
+
+	// HealthCheckInterval is the time between two health checks of a node
+	// that is down. Nodes are checked with a GET /_up request and marked
+	// up again once they respond with a status below 500.
+	// Defaults to 5 seconds.
+	HealthCheckInterval time.Duration
+
+	// HealthCheckTimeout limits the duration of a health check.
+	// Defaults to 2 seconds.
+	HealthCheckTimeout time.Duration
+}
+
+const (
+	defaultHealthCheckInterval = 5 * time.Second
+	defaultHealthCheckTimeout  = 2 * time.Second
+)
+
+// NewClusterClient creates a Client that spreads requests over several
+// CouchDB nodes of a cluster. Nodes that fail are skipped until a health
+// check finds them up again. If all nodes are down, requests go to the
+// node that failed first.
+//
+// Requests that can't connect to a node are sent to the next one right
+// away, whatever their method, as they have not reached the server. Other
+// failed requests are sent to another node according to the retry policy,
+// which defaults to one attempt per node. It can be changed with
+// SetRetryPolicy. Client.URL returns the URL of the first node.
+//
+// The addresses are used as in NewClient, client and auth are shared by
+// all nodes.
+func NewClusterClient(addrs []*url.URL, client *http.Client, auth Auth, opts *ClusterOptions) (*Client, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("couchdb.NewClusterClient: no node addresses")
+	}
+	c := NewClient(addrs[0], client, auth)
+	cl := &cluster{opts: opts.withDefaults()}
+	for _, addr := range addrs {
+		prefixAddr := *addr
+		prefixAddr.User, prefixAddr.RawQuery, prefixAddr.Fragment = nil, "", ""
+		cl.nodes = append(cl.nodes, &node{prefix: strings.TrimRight(prefixAddr.String(), "/")})
+	}
+	c.transport.cluster = cl
+	c.SetRetryPolicy(&RetryPolicy{MaxAttempts: len(addrs)})
+	return c, nil
+}
+
+// NodeStatus describes a node of a cluster client.
+type NodeStatus struct {
+	URL      string
+	Up       bool
+	InFlight int
+}
+
+// Nodes returns the status of the nodes of a client created by
+// NewClusterClient, or nil for other clients.
+func (c *Client) Nodes() []NodeStatus {
+	if c.cluster == nil {
+		return nil
+	}
+	return c.cluster.status()
+}
+
+// withDefaults returns a copy of the options with all defaults applied.
+func (o *ClusterOptions) withDefaults() ClusterOptions {
+	var r ClusterOptions
+	if o != nil {
+		r = *o
+	}
+	if r.FailureThreshold <= 0 {
+		r.FailureThreshold = 1
+	}
+	if r.HealthCheckInterval <= 0 {
+		r.HealthCheckInterval = defaultHealthCheckInterval
+	}
+	if r.HealthCheckTimeout <= 0 {
+		r.HealthCheckTimeout = defaultHealthCheckTimeout
+	}
+	return r
+}
+
+type node struct {
+	prefix   string
+	inFlight int
+	failures int       // consecutive connection failures
+	down     time.Time // zero while the node is up
+	checkAt  time.Time // time of the next health check while down
+	checking bool
+}
+
+type cluster struct {
+	opts  ClusterOptions
+	mu    sync.Mutex
+	nodes []*node
+	next  int // next node for RoundRobin
+}
+
+// pick selects the node for the next request and counts it in flight.
+// Nodes in skip, which have failed the request already, are only picked
+// if all other nodes have too. Health checks of down nodes that are due
+// are started in the background.
+func (c *cluster) pick(t *transport, skip map[*node]bool) *node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	best, oldest := -1, -1
+	for i := range c.nodes {
+		idx := (c.next + i) % len(c.nodes)
+		n := c.nodes[idx]
+		if !n.down.IsZero() {
+			if !n.checking && now.After(n.checkAt) {
+				n.checking = true
+				go c.check(t, n)
+			}
+			if !skip[n] && (oldest < 0 || n.down.Before(c.nodes[oldest].down)) {
+				oldest = idx
+			}
+			continue
+		}
+		if skip[n] {
+			continue
+		}
+		if best < 0 || c.opts.Balancing == LeastLoaded && n.inFlight < c.nodes[best].inFlight {
+			best = idx
+		}
+		if c.opts.Balancing == RoundRobin {
+			break
+		}
+	}
+	if best < 0 {
+		best = oldest
+	}
+	if best < 0 {
+		// Every node has been tried, start over.
+		best = c.next
+	}
+	c.next = (best + 1) % len(c.nodes)
+	n := c.nodes[best]
+	n.inFlight++
+	return n
+}
+
+// done records the outcome of a request sent to n. The request stays in
+// flight until release is called.
+func (c *cluster) done(n *node, failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !failed {
+		n.failures, n.down = 0, time.Time{}
+		return
+	}
+	n.failures++
+	if n.failures >= c.opts.FailureThreshold && n.down.IsZero() {
+		n.down = time.Now()
+		n.checkAt = n.down.Add(c.opts.HealthCheckInterval)
+	}
+}
+
+// release marks a request sent to n as finished.
+func (c *cluster) release(n *node) {
+	c.mu.Lock()
+	n.inFlight--
+	c.mu.Unlock()
+}
+
+// check sends a health check to a down node.
+func (c *cluster) check(t *transport, n *node) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.HealthCheckTimeout)
+	defer cancel()
+	up := false
+	if req, err := http.NewRequest("GET", n.prefix+"/_up", nil); err == nil {
+		if resp, err := t.http.Do(req.WithContext(ctx)); err == nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			up = resp.StatusCode < 500
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n.checking = false
+	if up {
+		n.failures, n.down = 0, time.Time{}
+	} else {
+		n.checkAt = time.Now().Add(c.opts.HealthCheckInterval)
+	}
+}
+
+func (c *cluster) status() []NodeStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	nodes := make([]NodeStatus, len(c.nodes))
+	for i, n := range c.nodes {
+		nodes[i] = NodeStatus{URL: n.prefix, Up: n.down.IsZero(), InFlight: n.inFlight}
+	}
+	return nodes
+}
+
+// roundTrip sends req, which is addressed to the prefix of t, to a node
+// of the cluster. If the node can't be reached, the request is sent to
+// the next one, whatever its method, as it has not arrived at the server.
+// The request stays in flight on its node until the response body is
+// closed, so that LeastLoaded balancing counts feeds and streamed rows.
+func (c *cluster) roundTrip(t *transport, req *http.Request) (*http.Response, error) {
+	tried := make(map[*node]bool, len(c.nodes))
+	for {
+		n := c.pick(t, tried)
+		tried[n] = true
+		u, err := url.Parse(n.prefix + strings.TrimPrefix(req.URL.String(), t.prefix))
+		if err != nil {
+			c.release(n)
+			return nil, err
+		}
+		r := new(http.Request)
+		*r = *req
+		r.URL, r.Host = u, ""
+		resp, err := t.http.Do(r)
+		// A cancelled request says nothing about the node.
+		c.done(n, err != nil && req.Context().Err() == nil)
+		if err == nil {
+			resp.Body = &nodeBody{ReadCloser: resp.Body, release: func() { c.release(n) }}
+			return resp, nil
+		}
+		c.release(n)
+		if !isDialError(err) || len(tried) == len(c.nodes) || req.Context().Err() != nil {
+			return nil, err
+		}
+		next, rerr := replayRequest(req)
+		if rerr != nil || next == nil {
+			return nil, err
+		}
+		req = next
+	}
+}
+
+// isDialError reports whether err is a failure to connect, which means
+// the request has not been sent.
+func isDialError(err error) bool {
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+	operr, ok := err.(*net.OpError)
+	return ok && operr.Op == "dial"
+}
+
+// nodeBody is the body of a response of a node, which releases the
+// request when it is closed.
+type nodeBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *nodeBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,287 @@
+package couchdb_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cabify/go-couchdb"
+)
+
+type clusterRecorder struct {
+	mu    sync.Mutex
+	hosts []string
+	fail  map[string]bool
+}
+
+func (r *clusterRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req.URL.Path != "/_up" {
+		r.hosts = append(r.hosts, req.URL.Host)
+	}
+	if r.fail[req.URL.Host] {
+		return nil, errors.New("connection refused")
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader(`{}`)),
+		Request:    req,
+	}, nil
+}
+
+func (r *clusterRecorder) setFail(host string, fail bool) {
+	r.mu.Lock()
+	r.fail[host] = fail
+	r.mu.Unlock()
+}
+
+func (r *clusterRecorder) takeHosts() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	hosts := r.hosts
+	r.hosts = nil
+	return hosts
+}
+
+func clusterAddrs(hosts ...string) []*url.URL {
+	addrs := make([]*url.URL, len(hosts))
+	for i, host := range hosts {
+		addrs[i] = asURL("http://" + host + ":5984/")
+	}
+	return addrs
+}
+
+func TestClusterRoundRobin(t *testing.T) {
+	rt := &clusterRecorder{fail: map[string]bool{}}
+	var paths []string
+	c, err := couchdb.NewClusterClient(clusterAddrs("node1", "node2", "node3"),
+		&http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			paths = append(paths, req.URL.EscapedPath())
+			return rt.RoundTrip(req)
+		})}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "c.URL()", "http://node1:5984", c.URL())
+
+	db := c.DB("db")
+	for i := 0; i < 4; i++ {
+		if err := db.Get("a/b", new(map[string]interface{}), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(t, "hosts", []string{"node1:5984", "node2:5984", "node3:5984", "node1:5984"}, rt.takeHosts())
+	check(t, "path", "/db/a%2Fb", paths[0])
+}
+
+func TestClusterFailover(t *testing.T) {
+	rt := &clusterRecorder{fail: map[string]bool{"node1:5984": true}}
+	c, err := couchdb.NewClusterClient(clusterAddrs("node1", "node2"), &http.Client{Transport: rt}, nil,
+		&couchdb.ClusterOptions{HealthCheckInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetRetryPolicy(&couchdb.RetryPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond})
+
+	if err := c.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "hosts", []string{"node1:5984", "node2:5984"}, rt.takeHosts())
+	check(t, "c.Nodes()", []couchdb.NodeStatus{
+		{URL: "http://node1:5984", Up: false},
+		{URL: "http://node2:5984", Up: true},
+	}, c.Nodes())
+
+	t.Log("-- node1 is skipped while it is down")
+	for i := 0; i < 2; i++ {
+		if err := c.Ping(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(t, "hosts", []string{"node2:5984", "node2:5984"}, rt.takeHosts())
+
+	t.Log("-- node1 comes back after a health check")
+	rt.setFail("node1:5984", false)
+	deadline := time.Now().Add(time.Second)
+	for !c.Nodes()[0].Up {
+		if time.Now().After(deadline) {
+			t.Fatal("node1 not up after health check")
+		}
+		time.Sleep(time.Millisecond)
+		c.Ping()
+	}
+}
+
+func TestClusterLeastLoaded(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/slow/doc" {
+			close(started)
+			<-release
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(`{}`)),
+			Request:    req,
+		}, nil
+	})
+	var (
+		mu    sync.Mutex
+		hosts []string
+	)
+	record := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		hosts = append(hosts, req.URL.Host)
+		mu.Unlock()
+		return rt(req)
+	})
+	c, err := couchdb.NewClusterClient(clusterAddrs("node1", "node2"), &http.Client{Transport: record}, nil,
+		&couchdb.ClusterOptions{Balancing: couchdb.LeastLoaded})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.DB("slow").Get("doc", new(map[string]interface{}), nil)
+		close(done)
+	}()
+	<-started
+	c.Ping()
+	c.Ping()
+	close(release)
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	check(t, "hosts", []string{"node1:5984", "node2:5984", "node2:5984"}, hosts)
+}
+
+func TestClusterNoNodes(t *testing.T) {
+	if _, err := couchdb.NewClusterClient(nil, nil, nil, nil); err == nil {
+		t.Error("expected an error without nodes")
+	}
+}
+
+func TestClusterFailoverOnDialError(t *testing.T) {
+	var (
+		hosts  []string
+		bodies []string
+	)
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		hosts = append(hosts, req.URL.Host)
+		body, _ := ioutil.ReadAll(req.Body)
+		bodies = append(bodies, string(body))
+		if req.URL.Host == "node1:5984" {
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+		}
+		return &http.Response{
+			StatusCode: http.StatusCreated,
+			Body:       ioutil.NopCloser(strings.NewReader(`{"id":"doc","rev":"1-a"}`)),
+			Request:    req,
+		}, nil
+	})
+	c, err := couchdb.NewClusterClient(clusterAddrs("node1", "node2"), &http.Client{Transport: rt}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetRetryPolicy(nil)
+
+	id, rev, err := c.DB("db").Post(map[string]int{"field": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "id and rev", []string{"doc", "1-a"}, []string{id, rev})
+	check(t, "hosts", []string{"node1:5984", "node2:5984"}, hosts)
+	check(t, "body sent to node2", `{"field":1}`, bodies[1])
+
+	t.Log("-- other errors are not sent to another node")
+	hosts = nil
+	rt2 := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		hosts = append(hosts, req.URL.Host)
+		return nil, errors.New("connection reset")
+	})
+	c, err = couchdb.NewClusterClient(clusterAddrs("node1", "node2"), &http.Client{Transport: rt2}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetRetryPolicy(nil)
+	if _, _, err := c.DB("db").Post(map[string]int{"field": 1}); err == nil {
+		t.Fatal("expected an error")
+	}
+	check(t, "hosts after reset", []string{"node1:5984"}, hosts)
+}
+
+func TestClusterRoundRobinSkipsDownNode(t *testing.T) {
+	rt := &clusterRecorder{fail: map[string]bool{"node2:5984": true}}
+	c, err := couchdb.NewClusterClient(clusterAddrs("node1", "node2", "node3"), &http.Client{Transport: rt}, nil,
+		&couchdb.ClusterOptions{HealthCheckInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Ping()
+	c.Ping()
+	rt.takeHosts()
+	check(t, "node2 up", false, c.Nodes()[1].Up)
+
+	for i := 0; i < 4; i++ {
+		if err := c.Ping(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(t, "hosts", []string{"node1:5984", "node3:5984", "node1:5984", "node3:5984"}, rt.takeHosts())
+}
+
+func TestClusterLeastLoadedCountsOpenBodies(t *testing.T) {
+	var hosts []string
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		hosts = append(hosts, req.URL.Host)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(`{"total_rows":0,"offset":0,"rows":[`)),
+			Request:    req,
+		}, nil
+	})
+	c, err := couchdb.NewClusterClient(clusterAddrs("node1", "node2"), &http.Client{Transport: rt}, nil,
+		&couchdb.ClusterOptions{Balancing: couchdb.LeastLoaded})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := c.DB("db").AllDocsRows(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "in flight while rows are open", []int{1, 0}, []int{c.Nodes()[0].InFlight, c.Nodes()[1].InFlight})
+	c.Ping()
+	c.Ping()
+	check(t, "hosts", []string{"node1:5984", "node2:5984", "node2:5984"}, hosts)
+	rows.Close()
+	check(t, "in flight after Close", []int{0, 0}, []int{c.Nodes()[0].InFlight, c.Nodes()[1].InFlight})
+}
+
+func TestClusterReleasesPutSecurity(t *testing.T) {
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(`{"ok":true}`)),
+			Request:    req,
+		}, nil
+	})
+	c, err := couchdb.NewClusterClient(clusterAddrs("node1"), &http.Client{Transport: rt}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.DB("db").PutSecurity(&couchdb.Security{}); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "in flight", 0, c.Nodes()[0].InFlight)
+}
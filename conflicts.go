@@ -0,0 +1,268 @@
+package couchdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Revision is a leaf revision of a document.
+type Revision struct {
+	Rev string
+	Doc json.RawMessage // including _id and _rev
+}
+
+// DecodeDoc unmarshals the document of the revision into v.
+func (r *Revision) DecodeDoc(v interface{}) error {
+	return decodeRaw(r.Doc, v)
+}
+
+// Conflicts retrieves all leaf revisions of a document, with
+// open_revs=all. The winning revision, which is the one returned by Get,
+// comes first, followed by the conflicting revisions in the order CouchDB
+// uses to pick the winner. Deleted leaves are left out, like they are
+// from _conflicts, so the result is empty if the document is deleted.
+// A document without conflicts has a single revision.
+func (db *DB) Conflicts(id string) ([]Revision, error) {
+	// open_revs=all is sent as is, it would be quoted as a JSON key.
+	req, err := db.newRequest(db.opctx("Conflicts"), "GET", path(db.name, id)+"?open_revs=all", nil)
+	if err != nil {
+		return nil, err
+	}
+	// Without it, the revisions are sent as multipart/mixed.
+	req.Header.Set("Accept", "application/json")
+	resp, err := db.send(req)
+	if err != nil {
+		return nil, err
+	}
+	var leaves []struct {
+		OK json.RawMessage `json:"ok"`
+	}
+	if err := readBody(resp, &leaves); err != nil {
+		return nil, err
+	}
+
+	var revs []Revision
+	for _, leaf := range leaves {
+		if leaf.OK == nil {
+			continue // missing
+		}
+		var meta struct {
+			Rev     string `json:"_rev"`
+			Deleted bool   `json:"_deleted"`
+		}
+		if err := json.Unmarshal(leaf.OK, &meta); err != nil {
+			return nil, err
+		}
+		if !meta.Deleted {
+			revs = append(revs, Revision{Rev: meta.Rev, Doc: leaf.OK})
+		}
+	}
+	sort.Slice(revs, func(i, j int) bool {
+		return revWins(revs[i].Rev, revs[j].Rev)
+	})
+	return revs, nil
+}
+
+// revWins reports whether the leaf revision a wins over b. The revision
+// with the longer history wins, ties are broken by the greater hash.
+func revWins(a, b string) bool {
+	apos, ahash := splitRev(a)
+	bpos, bhash := splitRev(b)
+	if apos != bpos {
+		return apos > bpos
+	}
+	return ahash > bhash
+}
+
+func splitRev(rev string) (pos int, hash string) {
+	parts := strings.SplitN(rev, "-", 2)
+	pos, _ = strconv.Atoi(parts[0])
+	if len(parts) == 2 {
+		hash = parts[1]
+	}
+	return pos, hash
+}
+
+// MergeFunc merges the leaf revisions of a document, as returned by
+// Conflicts, into the document that replaces them. It can return ErrDelete
+// to delete all of them instead.
+type MergeFunc func(revs []Revision) (doc interface{}, err error)
+
+// ResolveConflicts resolves the conflicts of a document. It retrieves the
+// leaf revisions with Conflicts and, if there is more than one, passes
+// them to merge. In a single _bulk_docs request, the merged document is
+// written as a new revision of the winning one, and the other revisions
+// are deleted. The _id and _rev of the merged document are set by
+// ResolveConflicts.
+//
+// Like all _bulk_docs requests, the writes are not atomic. Should some of
+// them fail with a conflict because the document is changed concurrently,
+// the remaining conflicts are resolved by starting over, like Update
+// does. Other errors of merge are returned as is.
+//
+// It returns the revision of the merged document, or the current revision
+// if the document has no conflicts. The revision is empty if the document
+// is deleted.
+func (db *DB) ResolveConflicts(id string, merge MergeFunc) (rev string, err error) {
+	for attempt := 1; ; attempt++ {
+		rev, err = db.resolveConflicts(id, merge)
+		if !Conflict(err) || attempt >= updateRetry.MaxAttempts {
+			return rev, err
+		}
+		select {
+		case <-time.After(updateRetry.backoff(attempt)):
+		case <-db.ctx.Done():
+			return "", db.ctx.Err()
+		}
+	}
+}
+
+// resolveConflicts makes a single attempt of ResolveConflicts.
+func (db *DB) resolveConflicts(id string, merge MergeFunc) (string, error) {
+	revs, err := db.Conflicts(id)
+	if err != nil || len(revs) == 0 {
+		return "", err
+	}
+	if len(revs) == 1 {
+		return revs[0].Rev, nil
+	}
+
+	var docs []interface{}
+	losers := revs[1:]
+	doc, err := merge(revs)
+	deleted := err == ErrDelete
+	switch {
+	case deleted:
+		losers = revs
+	case err != nil:
+		return "", err
+	default:
+		winner, err := mergedDoc(id, revs[0].Rev, doc)
+		if err != nil {
+			return "", err
+		}
+		docs = append(docs, winner)
+	}
+	for _, r := range losers {
+		docs = append(docs, map[string]interface{}{"_id": id, "_rev": r.Rev, "_deleted": true})
+	}
+
+	res, err := db.BulkDocs(docs...)
+	if err != nil {
+		return "", err
+	}
+	for _, r := range res {
+		if r.Error != "" {
+			return "", db.bulkError(r)
+		}
+	}
+	if deleted {
+		return "", nil
+	}
+	return res[0].Rev, nil
+}
+
+// mergedDoc returns doc with the given _id and _rev.
+func mergedDoc(id, rev string, doc interface{}) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil || fields == nil {
+		return nil, fmt.Errorf("couchdb.ResolveConflicts: merged document is not an object: %s", data)
+	}
+	fields["_id"], _ = json.Marshal(id)
+	fields["_rev"], _ = json.Marshal(rev)
+	return fields, nil
+}
+
+// bulkError converts the error of a _bulk_docs result into an *Error,
+// so it can be checked with Conflict.
+func (db *DB) bulkError(r BulkDocsResp) error {
+	status := http.StatusBadRequest
+	switch r.Error {
+	case "conflict":
+		status = http.StatusConflict
+	case "forbidden":
+		status = http.StatusForbidden
+	case "unauthorized":
+		status = http.StatusUnauthorized
+	}
+	return &Error{
+		Method:     http.MethodPost,
+		URL:        db.prefix + path(db.name, "_bulk_docs"),
+		StatusCode: status,
+		ErrorCode:  r.Error,
+		Reason:     fmt.Sprintf("%s: %s", r.ID, r.Reason),
+	}
+}
+
+// ScanConflicts returns the IDs of the documents with conflicts. Without
+// a view, all documents are read from _all_docs with conflicts=true. As
+// this is slow on large databases, ddoc and view can name a view that
+// only emits the documents with conflicts, such as
+//
+//	function(doc) {
+//		if (doc._conflicts) {
+//			emit(null, null);
+//		}
+//	}
+func (db *DB) ScanConflicts(ddoc, view string) ([]string, error) {
+	var (
+		rows *Rows
+		err  error
+	)
+	if view == "" {
+		rows, err = db.AllDocsRows(Options{"include_docs": true, "conflicts": true})
+	} else {
+		rows, err = db.ViewRows(ddoc, view, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	seen := make(map[string]bool)
+	for {
+		ok, err := rows.Next()
+		if !ok {
+			return ids, err
+		}
+		if view == "" {
+			var doc struct {
+				Conflicts []string `json:"_conflicts"`
+			}
+			if rows.DecodeDoc(&doc) != nil || len(doc.Conflicts) == 0 {
+				continue
+			}
+		}
+		if !seen[rows.ID] {
+			seen[rows.ID] = true
+			ids = append(ids, rows.ID)
+		}
+	}
+}
+
+// RepairConflicts resolves the conflicts of all documents found by
+// ScanConflicts with ResolveConflicts. It stops at the first error and
+// returns the IDs of the documents that were repaired until then.
+func (db *DB) RepairConflicts(ddoc, view string, merge MergeFunc) (repaired []string, err error) {
+	ids, err := db.ScanConflicts(ddoc, view)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if _, err := db.ResolveConflicts(id, merge); err != nil {
+			return repaired, err
+		}
+		repaired = append(repaired, id)
+	}
+	return repaired, nil
+}
@@ -0,0 +1,184 @@
+package couchdb_test
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/cabify/go-couchdb"
+)
+
+const leaves = `[
+	{"ok": {"_id": "doc", "_rev": "2-a", "field": 1}},
+	{"ok": {"_id": "doc", "_rev": "3-c", "_deleted": true}},
+	{"ok": {"_id": "doc", "_rev": "2-b", "field": 2}},
+	{"missing": "1-x"},
+	{"ok": {"_id": "doc", "_rev": "3-a", "field": 3}}
+]`
+
+func TestConflicts(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		check(t, "query", "open_revs=all", req.URL.RawQuery)
+		check(t, "Accept", "application/json", req.Header.Get("Accept"))
+		io.WriteString(resp, leaves)
+	})
+
+	revs, err := c.DB("db").Conflicts("doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, r := range revs {
+		ids = append(ids, r.Rev)
+	}
+	check(t, "revs", []string{"3-a", "2-b", "2-a"}, ids)
+	var doc testDocument
+	if err := revs[1].DecodeDoc(&doc); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "doc", testDocument{ID: "doc", Rev: "2-b", Field: 2}, doc)
+}
+
+func TestResolveConflicts(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, leaves)
+	})
+	var posts int
+	c.Handle("POST /db/_bulk_docs", func(resp http.ResponseWriter, req *http.Request) {
+		posts++
+		body, _ := ioutil.ReadAll(req.Body)
+		check(t, "body", `{"docs":[`+
+			`{"_id":"doc","_rev":"3-a","field":6},`+
+			`{"_deleted":true,"_id":"doc","_rev":"2-b"},`+
+			`{"_deleted":true,"_id":"doc","_rev":"2-a"}]}`, string(body))
+		if posts == 1 {
+			io.WriteString(resp, `[{"ok":true,"id":"doc","rev":"4-d"},
+				{"id":"doc","error":"conflict","reason":"Document update conflict."},
+				{"ok":true,"id":"doc","rev":"3-e"}]`)
+			return
+		}
+		io.WriteString(resp, `[{"ok":true,"id":"doc","rev":"4-d"},
+			{"ok":true,"id":"doc","rev":"3-f"},
+			{"ok":true,"id":"doc","rev":"3-e"}]`)
+	})
+
+	merge := func(revs []couchdb.Revision) (interface{}, error) {
+		sum := 0
+		for _, r := range revs {
+			var doc testDocument
+			if err := r.DecodeDoc(&doc); err != nil {
+				return nil, err
+			}
+			sum += doc.Field
+		}
+		return testDocument{ID: "other", Field: sum}, nil
+	}
+	rev, err := c.DB("db").ResolveConflicts("doc", merge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "rev", "4-d", rev)
+	check(t, "number of posts", 2, posts)
+}
+
+func TestResolveConflictsDelete(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, `[{"ok":{"_id":"doc","_rev":"2-a"}},{"ok":{"_id":"doc","_rev":"2-b"}}]`)
+	})
+	c.Handle("POST /db/_bulk_docs", func(resp http.ResponseWriter, req *http.Request) {
+		var body struct{ Docs []map[string]interface{} }
+		json.NewDecoder(req.Body).Decode(&body)
+		check(t, "docs", []map[string]interface{}{
+			{"_id": "doc", "_rev": "2-b", "_deleted": true},
+			{"_id": "doc", "_rev": "2-a", "_deleted": true},
+		}, body.Docs)
+		io.WriteString(resp, `[{"ok":true,"id":"doc","rev":"3-c"},{"ok":true,"id":"doc","rev":"3-d"}]`)
+	})
+
+	rev, err := c.DB("db").ResolveConflicts("doc", func([]couchdb.Revision) (interface{}, error) {
+		return nil, couchdb.ErrDelete
+	})
+	check(t, "rev", "", rev)
+	check(t, "error", nil, err)
+}
+
+func TestResolveConflictsWithoutConflicts(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, `[{"ok":{"_id":"doc","_rev":"2-a"}},{"ok":{"_id":"doc","_rev":"3-b","_deleted":true}}]`)
+	})
+
+	rev, err := c.DB("db").ResolveConflicts("doc", func([]couchdb.Revision) (interface{}, error) {
+		t.Error("merge called without conflicts")
+		return nil, nil
+	})
+	check(t, "rev", "2-a", rev)
+	check(t, "error", nil, err)
+}
+
+func TestScanConflicts(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_all_docs", func(resp http.ResponseWriter, req *http.Request) {
+		check(t, "query", "conflicts=true&include_docs=true", req.URL.RawQuery)
+		io.WriteString(resp, `{"total_rows":3,"offset":0,"rows":[
+			{"id":"a","key":"a","value":{"rev":"2-a"},"doc":{"_id":"a","_rev":"2-a","_conflicts":["2-b"]}},
+			{"id":"b","key":"b","value":{"rev":"1-a"},"doc":{"_id":"b","_rev":"1-a"}},
+			{"id":"c","key":"c","value":{"rev":"3-a"},"doc":{"_id":"c","_rev":"3-a","_conflicts":["2-b","2-c"]}}
+		]}`)
+	})
+	ids, err := c.DB("db").ScanConflicts("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "ids from _all_docs", []string{"a", "c"}, ids)
+
+	c.Handle("GET /db/_design/test/_view/conflicts", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, `{"total_rows":3,"offset":0,"rows":[
+			{"id":"a","key":null,"value":null},
+			{"id":"a","key":null,"value":null},
+			{"id":"c","key":null,"value":null}
+		]}`)
+	})
+	ids, err = c.DB("db").ScanConflicts("test", "conflicts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "ids from view", []string{"a", "c"}, ids)
+}
+
+func TestRepairConflicts(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_design/test/_view/conflicts", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, `{"total_rows":2,"offset":0,"rows":[
+			{"id":"a","key":null,"value":null},
+			{"id":"b","key":null,"value":null}
+		]}`)
+	})
+	c.Handle("GET /db/a", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, `[{"ok":{"_id":"a","_rev":"2-a"}},{"ok":{"_id":"a","_rev":"2-b"}}]`)
+	})
+	c.Handle("GET /db/b", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, `[{"ok":{"_id":"b","_rev":"2-a"}},{"ok":{"_id":"b","_rev":"2-b"}}]`)
+	})
+	c.Handle("POST /db/_bulk_docs", func(resp http.ResponseWriter, req *http.Request) {
+		var body struct{ Docs []map[string]interface{} }
+		json.NewDecoder(req.Body).Decode(&body)
+		if body.Docs[0]["_id"] == "b" {
+			resp.WriteHeader(http.StatusForbidden)
+			io.WriteString(resp, `{"error":"forbidden","reason":"read only"}`)
+			return
+		}
+		io.WriteString(resp, `[{"ok":true,"id":"a","rev":"3-b"},{"ok":true,"id":"a","rev":"3-a"}]`)
+	})
+
+	repaired, err := c.DB("db").RepairConflicts("test", "conflicts", func(revs []couchdb.Revision) (interface{}, error) {
+		return revs[0].Doc, nil
+	})
+	check(t, "repaired", []string{"a"}, repaired)
+	check(t, "Forbidden error", true, couchdb.ErrorStatus(err, http.StatusForbidden))
+}
@@ -0,0 +1,305 @@
+package couchdbtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// seqString formats an update sequence. Like those of CouchDB 2 and later,
+// sequences are strings that start with a number.
+func seqString(seq int) string {
+	return strconv.Itoa(seq) + "-fake"
+}
+
+// parseSeq parses the since parameter, a number or a sequence string.
+func parseSeq(v interface{}) (seq int, now bool, err error) {
+	switch v := v.(type) {
+	case float64:
+		return int(v), false, nil
+	case string:
+		if v == "now" {
+			return 0, true, nil
+		}
+		seq, err = strconv.Atoi(strings.SplitN(v, "-", 2)[0])
+		return seq, false, err
+	}
+	return 0, false, fmt.Errorf("invalid sequence: %v", v)
+}
+
+// changesQuery holds the parameters of a changes feed.
+type changesQuery struct {
+	feed        string
+	since       int
+	sinceNow    bool
+	limit       int // -1 if not given
+	descending  bool
+	includeDocs bool
+	timeout     time.Duration   // ignored if heartbeat is set
+	heartbeat   time.Duration   // zero if not given
+	docIDs      map[string]bool // of the _doc_ids filter, nil if not given
+}
+
+func parseChangesQuery(p map[string]interface{}) (*changesQuery, error) {
+	q := &changesQuery{feed: "normal", limit: -1, timeout: time.Minute}
+	var filter string
+	for k, v := range p {
+		var (
+			err error
+			ms  int
+		)
+		switch k {
+		case "feed":
+			q.feed, err = stringParam(v)
+		case "since":
+			q.since, q.sinceNow, err = parseSeq(v)
+		case "limit":
+			q.limit, err = intParam(v)
+		case "descending":
+			q.descending, err = boolParam(v)
+		case "include_docs":
+			q.includeDocs, err = boolParam(v)
+		case "timeout":
+			ms, err = intParam(v)
+			q.timeout = time.Duration(ms) * time.Millisecond
+		case "heartbeat":
+			if v == "true" {
+				q.heartbeat = time.Minute
+			} else {
+				ms, err = intParam(v)
+				q.heartbeat = time.Duration(ms) * time.Millisecond
+			}
+		case "filter":
+			filter, err = stringParam(v)
+		case "doc_ids":
+			ids, ok := v.([]interface{})
+			if !ok {
+				err = fmt.Errorf("not an array: %v", v)
+			}
+			q.docIDs = make(map[string]bool, len(ids))
+			for _, id := range ids {
+				if id, ok := id.(string); ok {
+					q.docIDs[id] = true
+				}
+			}
+		}
+		if err != nil {
+			return nil, badRequest("invalid value for %s: %v", k, err)
+		}
+	}
+	switch q.feed {
+	case "normal", "longpoll", "continuous", "eventsource":
+	default:
+		return nil, badRequest("Supported `feed` types: normal, continuous, eventsource, longpoll")
+	}
+	switch {
+	case filter == "" && q.docIDs == nil:
+	case filter == "_doc_ids" && q.docIDs != nil:
+	default:
+		return nil, badRequest("only the _doc_ids filter is supported")
+	}
+	return q, nil
+}
+
+type change struct {
+	seq  int
+	json map[string]interface{}
+}
+
+// changes returns the changes after a sequence, and the sequence of the
+// last one. Each document appears once, at the sequence of its last update.
+func (db *database) changes(q *changesQuery, since int) ([]change, int) {
+	var docs []*document
+	for id, doc := range db.docs {
+		if doc.seq > since && !doc.local() && (q.docIDs == nil || q.docIDs[id]) {
+			docs = append(docs, doc)
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].seq < docs[j].seq != q.descending
+	})
+	if q.limit >= 0 && q.limit < len(docs) {
+		docs = docs[:q.limit]
+	}
+	last := since
+	results := make([]change, 0, len(docs))
+	for _, doc := range docs {
+		cur := doc.current()
+		res := map[string]interface{}{
+			"seq":     seqString(doc.seq),
+			"id":      doc.id,
+			"changes": []map[string]string{{"rev": cur.rev}},
+		}
+		if cur.deleted {
+			res["deleted"] = true
+		}
+		if q.includeDocs {
+			res["doc"] = doc.json(cur, false)
+		}
+		results = append(results, change{doc.seq, res})
+		last = doc.seq
+	}
+	if q.descending && len(docs) > 0 {
+		last = docs[0].seq
+	}
+	return results, last
+}
+
+// timer returns a channel that receives when the timeout of the feed
+// expires. Feeds with heartbeats never time out.
+func (q *changesQuery) timer() (<-chan time.Time, func() bool) {
+	if q.heartbeat > 0 {
+		return nil, func() bool { return false }
+	}
+	t := time.NewTimer(q.timeout)
+	return t.C, t.Stop
+}
+
+// serveChanges serves the changes feed of a database. Longpoll and
+// continuous feeds wait for updates without holding the lock of the
+// server. They end when the timeout expires, the client goes away or the
+// database is deleted.
+func (s *Server) serveChanges(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != "GET" && r.Method != "HEAD" && r.Method != "POST" {
+		writeError(w, errNotAllowed)
+		return
+	}
+	p, err := params(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	q, err := parseChangesQuery(p)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s.mu.Lock()
+	db := s.dbs[name]
+	if db == nil {
+		s.mu.Unlock()
+		writeError(w, errDBMissing)
+		return
+	}
+	since := q.since
+	if q.sinceNow {
+		since = db.seq
+	}
+	if q.feed == "continuous" || q.feed == "eventsource" {
+		s.mu.Unlock()
+		s.streamChanges(w, r, db, q, since)
+		return
+	}
+
+	results, last := db.changes(q, since)
+	if q.feed == "longpoll" {
+		timeout, stop := q.timer()
+		defer stop()
+		for done := false; len(results) == 0 && !db.dropped && !done; {
+			changed := db.changed
+			s.mu.Unlock()
+			select {
+			case <-changed:
+			case <-timeout:
+				done = true
+			case <-r.Context().Done():
+				done = true
+			}
+			s.mu.Lock()
+			results, last = db.changes(q, since)
+		}
+	}
+	pending := 0
+	if !q.descending {
+		rest, _ := db.changes(&changesQuery{limit: -1, docIDs: q.docIDs}, last)
+		pending = len(rest)
+	}
+	s.mu.Unlock()
+	out := make([]map[string]interface{}, len(results))
+	for i, c := range results {
+		out[i] = c.json
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"results":  out,
+		"last_seq": seqString(last),
+		"pending":  pending,
+	})
+}
+
+func (s *Server) streamChanges(w http.ResponseWriter, r *http.Request, db *database, q *changesQuery, since int) {
+	if q.feed == "eventsource" {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(http.StatusOK)
+	flush := func() {
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	flush()
+
+	var heartbeat <-chan time.Time
+	if q.heartbeat > 0 {
+		ticker := time.NewTicker(q.heartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	timeout, stop := q.timer()
+	defer stop()
+	sent := 0
+	for {
+		s.mu.Lock()
+		results, _ := db.changes(q, since)
+		changed, dropped := db.changed, db.dropped
+		s.mu.Unlock()
+		for _, c := range results {
+			if q.limit >= 0 && sent >= q.limit {
+				break
+			}
+			line, _ := json.Marshal(c.json)
+			if q.feed == "eventsource" {
+				fmt.Fprintf(w, "data: %s\nid: %s\n\n", line, seqString(c.seq))
+			} else {
+				fmt.Fprintf(w, "%s\n", line)
+			}
+			since = c.seq
+			sent++
+		}
+		if q.limit >= 0 && sent >= q.limit || dropped {
+			endStream(w, q, since)
+			return
+		}
+		flush()
+		for waiting := true; waiting; {
+			select {
+			case <-changed:
+				waiting = false
+			case <-heartbeat:
+				if q.feed == "eventsource" {
+					fmt.Fprint(w, "event: heartbeat\ndata: \n\n")
+				} else {
+					fmt.Fprint(w, "\n")
+				}
+				flush()
+			case <-timeout:
+				endStream(w, q, since)
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// endStream writes the last line of a continuous feed.
+func endStream(w http.ResponseWriter, q *changesQuery, since int) {
+	if q.feed == "continuous" {
+		fmt.Fprintf(w, "{\"last_seq\":%q,\"pending\":0}\n", seqString(since))
+	}
+}
@@ -0,0 +1,142 @@
+package couchdbtest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cabify/go-couchdb"
+)
+
+func TestChanges(t *testing.T) {
+	srv, db := newDB(t)
+	defer srv.Close()
+
+	revs := map[string]string{}
+	for _, id := range []string{"a", "b", "a", "_local/x"} {
+		rev, err := db.Put(id, testDocument{}, revs[id])
+		if err != nil {
+			t.Fatal(err)
+		}
+		revs[id] = rev
+	}
+
+	feed, err := db.Changes(couchdb.Options{"include_docs": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids, seqs []interface{}
+	for {
+		ok, err := feed.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		ids = append(ids, feed.ID)
+		seqs = append(seqs, feed.Seq)
+		check(t, "Rev of "+feed.ID, revs[feed.ID], feed.Rev)
+	}
+	check(t, "ids", []interface{}{"b", "a"}, ids)
+	check(t, "seqs", []interface{}{"2-fake", "3-fake"}, seqs)
+	check(t, "last seq", "3-fake", feed.Seq)
+
+	feed, err = db.Changes(couchdb.Options{"since": "2-fake"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := feed.Next()
+	check(t, "id since 2", "a", feed.ID)
+	check(t, "Next", true, ok)
+	check(t, "Next error", nil, err)
+	feed.Close()
+}
+
+func TestLongpollChanges(t *testing.T) {
+	srv, db := newDB(t)
+	defer srv.Close()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		db.Put("doc", testDocument{}, "")
+	}()
+	feed, err := db.Changes(couchdb.Options{"feed": "longpoll", "since": "now"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := feed.Next()
+	check(t, "Next", true, ok)
+	check(t, "Next error", nil, err)
+	check(t, "id", "doc", feed.ID)
+	feed.Close()
+
+	feed, err = db.Changes(couchdb.Options{"feed": "longpoll", "since": "now", "timeout": 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err = feed.Next()
+	check(t, "Next after timeout", false, ok)
+	check(t, "Next error after timeout", nil, err)
+	check(t, "last seq after timeout", "1-fake", feed.Seq)
+}
+
+func TestContinuousChanges(t *testing.T) {
+	srv, db := newDB(t)
+	defer srv.Close()
+
+	if _, err := db.Put("old", testDocument{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, mode := range []string{"continuous", "eventsource"} {
+		feed, err := db.Changes(couchdb.Options{"feed": mode, "since": "now", "heartbeat": 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			time.Sleep(30 * time.Millisecond)
+			db.Put("new-"+mode, testDocument{}, "")
+		}()
+		ok, err := feed.Next()
+		check(t, mode+" Next", true, ok)
+		check(t, mode+" Next error", nil, err)
+		check(t, mode+" id", "new-"+mode, feed.ID)
+		feed.Close()
+	}
+
+	feed, err := db.ContinuousChanges(couchdb.Options{"timeout": 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for {
+		ok, err := feed.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		ids = append(ids, feed.ID)
+	}
+	check(t, "ids until timeout", []string{"old", "new-continuous", "new-eventsource"}, ids)
+	check(t, "last seq", "3-fake", feed.Seq)
+}
+
+func TestResilientChangesFromServer(t *testing.T) {
+	srv, db := newDB(t)
+	defer srv.Close()
+
+	feed, err := db.ResilientChanges(nil, &couchdb.ReconnectOptions{Heartbeat: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer feed.Close()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		db.Put("doc", testDocument{}, "")
+	}()
+	ok, err := feed.Next()
+	check(t, "Next", true, ok)
+	check(t, "Next error", nil, err)
+	check(t, "id", "doc", feed.ID)
+}
@@ -0,0 +1,553 @@
+package couchdbtest
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type database struct {
+	name     string
+	seq      int // update sequence
+	docs     map[string]*document
+	security map[string]interface{}
+
+	// changed is closed and replaced on every update, to wake up feeds.
+	// It is closed for good when the database is deleted.
+	changed chan struct{}
+	dropped bool
+}
+
+func newDatabase(name string) *database {
+	return &database{
+		name:     name,
+		docs:     make(map[string]*document),
+		security: make(map[string]interface{}),
+		changed:  make(chan struct{}),
+	}
+}
+
+func (db *database) info() map[string]interface{} {
+	var count, deleted int
+	for _, doc := range db.docs {
+		switch {
+		case doc.local():
+		case doc.current().deleted:
+			deleted++
+		default:
+			count++
+		}
+	}
+	return map[string]interface{}{
+		"db_name":             db.name,
+		"doc_count":           count,
+		"doc_del_count":       deleted,
+		"update_seq":          seqString(db.seq),
+		"instance_start_time": "0",
+	}
+}
+
+func (db *database) notify() {
+	close(db.changed)
+	db.changed = make(chan struct{})
+}
+
+func (db *database) drop() {
+	db.dropped = true
+	close(db.changed)
+}
+
+type document struct {
+	id   string
+	revs []*revision // oldest first, the last one is the current revision
+	seq  int         // of the last update
+}
+
+type revision struct {
+	rev     string
+	deleted bool
+	fields  map[string]interface{} // the content, without special fields
+	atts    map[string]*attachment
+}
+
+type attachment struct {
+	contentType string
+	data        []byte
+	revpos      int
+}
+
+func (a *attachment) md5() []byte {
+	sum := md5.Sum(a.data)
+	return sum[:]
+}
+
+// local reports whether doc is a local document. Those have no update
+// sequence and are neither listed by _all_docs nor in the changes feed.
+func (doc *document) local() bool {
+	return strings.HasPrefix(doc.id, "_local/")
+}
+
+func (doc *document) current() *revision {
+	return doc.revs[len(doc.revs)-1]
+}
+
+func (doc *document) revision(rev string) *revision {
+	for _, r := range doc.revs {
+		if r.rev == rev {
+			return r
+		}
+	}
+	return nil
+}
+
+// json returns a revision of the document as sent by CouchDB. Attachments
+// are inlined if withAtts is true, else they are sent as stubs.
+func (doc *document) json(r *revision, withAtts bool) map[string]interface{} {
+	m := make(map[string]interface{}, len(r.fields)+4)
+	for k, v := range r.fields {
+		m[k] = v
+	}
+	m["_id"], m["_rev"] = doc.id, r.rev
+	if r.deleted {
+		m["_deleted"] = true
+	}
+	if len(r.atts) > 0 {
+		atts := make(map[string]interface{}, len(r.atts))
+		for name, att := range r.atts {
+			a := map[string]interface{}{
+				"content_type": att.contentType,
+				"digest":       "md5-" + base64.StdEncoding.EncodeToString(att.md5()),
+				"length":       len(att.data),
+				"revpos":       att.revpos,
+			}
+			if withAtts {
+				a["data"] = att.data
+			} else {
+				a["stub"] = true
+			}
+			atts[name] = a
+		}
+		m["_attachments"] = atts
+	}
+	return m
+}
+
+// revisions returns the _revisions field of a revision of the document.
+func (doc *document) revisions(r *revision) map[string]interface{} {
+	var ids []string
+	for i := len(doc.revs) - 1; i >= 0; i-- {
+		if doc.revs[i] == r || len(ids) > 0 {
+			ids = append(ids, strings.SplitN(doc.revs[i].rev, "-", 2)[1])
+		}
+	}
+	return map[string]interface{}{"start": revPos(r.rev), "ids": ids}
+}
+
+func revPos(rev string) int {
+	pos, _ := strconv.Atoi(strings.SplitN(rev, "-", 2)[0])
+	return pos
+}
+
+// lookup returns a revision of a document, or its current revision if rev
+// is empty. Deleted documents are only found by revision.
+func (db *database) lookup(id, rev string) (*document, *revision, error) {
+	doc := db.docs[id]
+	if doc == nil {
+		return nil, nil, errMissing
+	}
+	if rev != "" {
+		if r := doc.revision(rev); r != nil {
+			return doc, r, nil
+		}
+		return nil, nil, errMissing
+	}
+	if doc.current().deleted {
+		return nil, nil, errDeleted
+	}
+	return doc, doc.current(), nil
+}
+
+// checkRev checks that rev is the current revision of doc, as required
+// for updates. New and deleted documents can be written without revision.
+func checkRev(doc *document, rev string) error {
+	if doc == nil || doc.current().deleted {
+		if rev != "" && (doc == nil || rev != doc.current().rev) {
+			return errConflict
+		}
+		return nil
+	}
+	if rev != doc.current().rev {
+		return errConflict
+	}
+	return nil
+}
+
+// put stores a new revision of a document. The rev argument is the
+// revision given in the query or the If-Match header, if any, it takes
+// precedence over the _rev of the body.
+func (db *database) put(id string, body map[string]interface{}, rev string) (string, error) {
+	if id == "" {
+		return "", badRequest("Document id must not be empty")
+	}
+	if strings.HasPrefix(id, "_") && !strings.HasPrefix(id, "_design/") && !strings.HasPrefix(id, "_local/") {
+		return "", &couchError{http.StatusBadRequest, "illegal_docid", "Only reserved document ids may start with underscore."}
+	}
+	if rev == "" {
+		rev, _ = body["_rev"].(string)
+	}
+	doc := db.docs[id]
+	if err := checkRev(doc, rev); err != nil {
+		return "", err
+	}
+	var prev *revision
+	if doc != nil && !doc.current().deleted {
+		prev = doc.current()
+	}
+	pos := nextPos(doc)
+	r := &revision{fields: make(map[string]interface{})}
+	for k, v := range body {
+		switch k {
+		case "_id", "_rev", "_revisions", "_conflicts", "_deleted_conflicts", "_revs_info", "_local_seq":
+		case "_deleted":
+			r.deleted, _ = v.(bool)
+		case "_attachments":
+			atts, err := parseAttachments(v, prev, pos)
+			if err != nil {
+				return "", err
+			}
+			r.atts = atts
+		default:
+			if strings.HasPrefix(k, "_") {
+				return "", &couchError{http.StatusBadRequest, "doc_validation", "Bad special document member: " + k}
+			}
+			r.fields[k] = v
+		}
+	}
+	if r.deleted && doc == nil {
+		return "", errMissing
+	}
+	return db.commit(id, doc, r, pos), nil
+}
+
+// parseAttachments parses the _attachments of a document body. Stubs
+// refer to the attachments of the previous revision.
+func parseAttachments(v interface{}, prev *revision, pos int) (map[string]*attachment, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, badRequest("_attachments is not an object")
+	}
+	atts := make(map[string]*attachment, len(m))
+	for name, a := range m {
+		am, ok := a.(map[string]interface{})
+		if !ok {
+			return nil, badRequest("attachment %s is not an object", name)
+		}
+		if stub, _ := am["stub"].(bool); stub {
+			if prev == nil || prev.atts[name] == nil {
+				return nil, &couchError{http.StatusPreconditionFailed, "missing_stub", "Invalid attachment stub for " + name}
+			}
+			atts[name] = prev.atts[name]
+			continue
+		}
+		encoded, _ := am["data"].(string)
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, badRequest("invalid data of attachment %s: %v", name, err)
+		}
+		ct, _ := am["content_type"].(string)
+		atts[name] = &attachment{contentType: ct, data: data, revpos: pos}
+	}
+	return atts, nil
+}
+
+func nextPos(doc *document) int {
+	if doc == nil {
+		return 1
+	}
+	if doc.local() {
+		n, _ := strconv.Atoi(strings.TrimPrefix(doc.current().rev, "0-"))
+		return n + 1
+	}
+	return revPos(doc.current().rev) + 1
+}
+
+// commit appends a new revision to a document, creating the document if
+// it is nil, and returns the new revision ID. Revision IDs are derived
+// from the content, like those of CouchDB.
+func (db *database) commit(id string, doc *document, r *revision, pos int) string {
+	if doc == nil {
+		doc = &document{id: id}
+		db.docs[id] = doc
+	}
+	if doc.local() {
+		r.rev = fmt.Sprintf("0-%d", pos)
+		doc.revs = append(doc.revs[:0], r)
+		return r.rev
+	}
+	h := md5.New()
+	if len(doc.revs) > 0 {
+		fmt.Fprintln(h, doc.current().rev)
+	}
+	fmt.Fprintln(h, r.deleted)
+	json.NewEncoder(h).Encode(r.fields)
+	names := make([]string, 0, len(r.atts))
+	for name := range r.atts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(h, "%s %s %x\n", name, r.atts[name].contentType, r.atts[name].md5())
+	}
+	r.rev = fmt.Sprintf("%d-%x", pos, h.Sum(nil))
+	doc.revs = append(doc.revs, r)
+	db.seq++
+	doc.seq = db.seq
+	db.notify()
+	return r.rev
+}
+
+func (db *database) delete(id, rev string) (string, error) {
+	doc, _, err := db.lookup(id, "")
+	if err != nil {
+		return "", err
+	}
+	if rev != doc.current().rev {
+		return "", errConflict
+	}
+	r := &revision{deleted: true, fields: make(map[string]interface{})}
+	return db.commit(id, doc, r, nextPos(doc)), nil
+}
+
+// updateAttachment stores a new revision of a document with the named
+// attachment replaced, or removed if att is nil.
+func (db *database) updateAttachment(id, rev, name string, att *attachment) (string, error) {
+	doc := db.docs[id]
+	if att == nil {
+		if _, cur, err := db.lookup(id, ""); err != nil {
+			return "", err
+		} else if cur.atts[name] == nil {
+			return "", errNoAttachment
+		}
+	}
+	if err := checkRev(doc, rev); err != nil {
+		return "", err
+	}
+	pos := nextPos(doc)
+	r := &revision{fields: make(map[string]interface{}), atts: make(map[string]*attachment)}
+	if doc != nil && !doc.current().deleted {
+		cur := doc.current()
+		for k, v := range cur.fields {
+			r.fields[k] = v
+		}
+		for k, v := range cur.atts {
+			r.atts[k] = v
+		}
+	}
+	if att != nil {
+		att.revpos = pos
+		r.atts[name] = att
+	} else {
+		delete(r.atts, name)
+	}
+	return db.commit(id, doc, r, pos), nil
+}
+
+// requestRev returns the revision of a request, given in the query or
+// the If-Match header.
+func requestRev(r *http.Request) string {
+	if rev := r.URL.Query().Get("rev"); rev != "" {
+		return rev
+	}
+	return strings.Trim(r.Header.Get("If-Match"), `"`)
+}
+
+func serveDoc(w http.ResponseWriter, r *http.Request, db *database, id string) {
+	switch r.Method {
+	case "GET", "HEAD":
+		q := r.URL.Query()
+		if q.Get("open_revs") != "" {
+			serveOpenRevs(w, db, id, q.Get("open_revs"))
+			return
+		}
+		doc, rev, err := db.lookup(id, q.Get("rev"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		m := doc.json(rev, q.Get("attachments") == "true")
+		if q.Get("revs") == "true" {
+			m["_revisions"] = doc.revisions(rev)
+		}
+		w.Header().Set("ETag", `"`+rev.rev+`"`)
+		writeJSON(w, http.StatusOK, m)
+	case "PUT":
+		var body map[string]interface{}
+		if err := readJSON(r, &body); err != nil {
+			writeError(w, err)
+			return
+		}
+		rev, err := db.put(id, body, requestRev(r))
+		writeUpdate(w, http.StatusCreated, id, rev, err)
+	case "DELETE":
+		rev, err := db.delete(id, requestRev(r))
+		writeUpdate(w, http.StatusOK, id, rev, err)
+	default:
+		writeError(w, errNotAllowed)
+	}
+}
+
+func writeUpdate(w http.ResponseWriter, status int, id, rev string, err error) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("ETag", `"`+rev+`"`)
+	writeJSON(w, status, map[string]interface{}{"ok": true, "id": id, "rev": rev})
+}
+
+// serveOpenRevs serves the leaf revisions of a document in JSON. As the
+// revision history is linear, the current revision is the only leaf.
+func serveOpenRevs(w http.ResponseWriter, db *database, id, param string) {
+	doc := db.docs[id]
+	var revs []string
+	if param != "all" && param != `"all"` {
+		if err := json.Unmarshal([]byte(param), &revs); err != nil {
+			writeError(w, badRequest("invalid open_revs: %v", err))
+			return
+		}
+	} else if doc != nil {
+		revs = []string{doc.current().rev}
+	} else {
+		writeError(w, errMissing)
+		return
+	}
+	results := make([]map[string]interface{}, 0, len(revs))
+	for _, rev := range revs {
+		if doc == nil || doc.revision(rev) == nil {
+			results = append(results, map[string]interface{}{"missing": rev})
+		} else {
+			results = append(results, map[string]interface{}{"ok": doc.json(doc.revision(rev), false)})
+		}
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+func serveAttachment(w http.ResponseWriter, r *http.Request, db *database, id, name string) {
+	switch r.Method {
+	case "GET", "HEAD":
+		_, rev, err := db.lookup(id, r.URL.Query().Get("rev"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		att := rev.atts[name]
+		if att == nil {
+			writeError(w, errNoAttachment)
+			return
+		}
+		w.Header().Set("Content-Type", att.contentType)
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(att.md5()))
+		w.Header().Set("Content-Length", strconv.Itoa(len(att.data)))
+		w.Header().Set("ETag", `"`+base64.StdEncoding.EncodeToString(att.md5())+`"`)
+		w.WriteHeader(http.StatusOK)
+		w.Write(att.data)
+	case "PUT":
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, badRequest("%v", err))
+			return
+		}
+		ct := r.Header.Get("Content-Type")
+		if ct == "" {
+			ct = "application/octet-stream"
+		}
+		rev, err := db.updateAttachment(id, requestRev(r), name, &attachment{contentType: ct, data: data})
+		writeUpdate(w, http.StatusCreated, id, rev, err)
+	case "DELETE":
+		rev, err := db.updateAttachment(id, requestRev(r), name, nil)
+		writeUpdate(w, http.StatusOK, id, rev, err)
+	default:
+		writeError(w, errNotAllowed)
+	}
+}
+
+func (s *Server) serveBulkDocs(w http.ResponseWriter, r *http.Request, db *database) {
+	if r.Method != "POST" {
+		writeError(w, errNotAllowed)
+		return
+	}
+	var req struct {
+		Docs     []map[string]interface{} `json:"docs"`
+		NewEdits *bool                    `json:"new_edits"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.NewEdits != nil && !*req.NewEdits {
+		writeError(w, &couchError{http.StatusNotImplemented, "not_implemented", "new_edits=false is not supported"})
+		return
+	}
+	results := make([]map[string]interface{}, 0, len(req.Docs))
+	for _, doc := range req.Docs {
+		id, _ := doc["_id"].(string)
+		if id == "" {
+			id = s.newID()
+		}
+		rev, err := db.put(id, doc, "")
+		if err != nil {
+			cerr := asCouchError(err)
+			results = append(results, map[string]interface{}{"id": id, "error": cerr.error, "reason": cerr.reason})
+		} else {
+			results = append(results, map[string]interface{}{"ok": true, "id": id, "rev": rev})
+		}
+	}
+	writeJSON(w, http.StatusCreated, results)
+}
+
+func serveBulkGet(w http.ResponseWriter, r *http.Request, db *database) {
+	if r.Method != "POST" {
+		writeError(w, errNotAllowed)
+		return
+	}
+	var req struct {
+		Docs []struct {
+			ID  string `json:"id"`
+			Rev string `json:"rev"`
+		} `json:"docs"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	results := make([]map[string]interface{}, 0, len(req.Docs))
+	for _, d := range req.Docs {
+		var entry map[string]interface{}
+		if doc, rev, err := db.lookup(d.ID, d.Rev); err != nil {
+			cerr, rev := asCouchError(err), d.Rev
+			if rev == "" {
+				rev = "undefined"
+			}
+			entry = map[string]interface{}{"error": map[string]string{
+				"id": d.ID, "rev": rev, "error": cerr.error, "reason": cerr.reason,
+			}}
+		} else {
+			entry = map[string]interface{}{"ok": doc.json(rev, false)}
+		}
+		results = append(results, map[string]interface{}{"id": d.ID, "docs": []interface{}{entry}})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+}
+
+func asCouchError(err error) *couchError {
+	if cerr, ok := err.(*couchError); ok {
+		return cerr
+	}
+	return &couchError{http.StatusInternalServerError, "internal_server_error", err.Error()}
+}
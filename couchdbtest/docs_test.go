@@ -0,0 +1,223 @@
+package couchdbtest_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/cabify/go-couchdb"
+)
+
+func TestDocumentRevisions(t *testing.T) {
+	srv, db := newDB(t)
+	defer srv.Close()
+
+	rev1, err := db.Put("doc", testDocument{Field: 1}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "rev1 prefix", true, strings.HasPrefix(rev1, "1-"))
+	_, err = db.Put("doc", testDocument{Field: 2}, "")
+	check(t, "Conflict(err) without rev", true, couchdb.Conflict(err))
+
+	rev2, err := db.Put("doc", testDocument{Field: 2}, rev1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "rev2 prefix", true, strings.HasPrefix(rev2, "2-"))
+	_, err = db.Put("doc", testDocument{Field: 3}, rev1)
+	check(t, "Conflict(err) with old rev", true, couchdb.Conflict(err))
+
+	var doc testDocument
+	if err := db.Get("doc", &doc, nil); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "doc", testDocument{ID: "doc", Rev: rev2, Field: 2}, doc)
+	if err := db.Get("doc", &doc, couchdb.Options{"rev": rev1}); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "doc at rev1", testDocument{ID: "doc", Rev: rev1, Field: 1}, doc)
+	rev, err := db.Rev("doc")
+	check(t, "Rev", rev2, rev)
+	check(t, "Rev error", nil, err)
+
+	rev3, err := db.Delete("doc", rev2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Get("doc", &doc, nil)
+	check(t, "NotFound(err) after delete", true, couchdb.NotFound(err))
+	rev4, err := db.Put("doc", testDocument{Field: 4}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "rev3 and rev4 prefixes", []string{"3", "4"}, []string{rev3[:1], rev4[:1]})
+
+	id, rev, err := db.Post(testDocument{Field: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "generated id", "00000000000000000000000000000001", id)
+	check(t, "posted rev prefix", true, strings.HasPrefix(rev, "1-"))
+}
+
+func TestLocalDocuments(t *testing.T) {
+	srv, db := newDB(t)
+	defer srv.Close()
+
+	rev, err := db.Put("_local/checkpoint", map[string]int{"seq": 1}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "first rev", "0-1", rev)
+	if rev, err = db.Put("_local/checkpoint", map[string]int{"seq": 2}, rev); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "second rev", "0-2", rev)
+
+	var res couchdb.AllDocsResult
+	if err := db.AllDocs(&res, nil); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "rows of _all_docs", 0, len(res.Rows))
+}
+
+func TestAttachments(t *testing.T) {
+	srv, db := newDB(t)
+	defer srv.Close()
+
+	att := &couchdb.Attachment{Name: "dir/file.txt", Type: "text/plain", Body: strings.NewReader("hello")}
+	rev1, err := db.PutAttachment("doc", att, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.Attachment("doc", "dir/file.txt", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(got.Body)
+	got.Body.(io.Closer).Close()
+	sum := md5.Sum([]byte("hello"))
+	check(t, "body", "hello", string(body))
+	check(t, "type", "text/plain", got.Type)
+	check(t, "MD5", sum[:], got.MD5)
+
+	var doc struct {
+		Attachments map[string]couchdb.AttachmentInfo `json:"_attachments"`
+	}
+	if err := db.Get("doc", &doc, nil); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "stub", couchdb.AttachmentInfo{
+		ContentType: "text/plain",
+		Digest:      "md5-XUFAKrxLKna5cZ2REBfFkg==",
+		Length:      5,
+		RevPos:      1,
+		Stub:        true,
+	}, doc.Attachments["dir/file.txt"])
+
+	// Updates that keep the stub keep the attachment.
+	stub := map[string]interface{}{
+		"field":        1,
+		"_attachments": map[string]interface{}{"dir/file.txt": map[string]bool{"stub": true}},
+	}
+	rev2, err := db.Put("doc", stub, rev1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := db.AttachmentMeta("doc", "dir/file.txt", rev2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "meta MD5", sum[:], meta.MD5)
+
+	rev3, err := db.DeleteAttachment("doc", "dir/file.txt", rev2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.AttachmentMeta("doc", "dir/file.txt", rev3)
+	check(t, "NotFound(err) after delete", true, couchdb.NotFound(err))
+	_, err = db.Attachment("doc", "dir/file.txt", rev1)
+	check(t, "attachment of rev1", nil, err)
+}
+
+func TestBulkDocsAndBulkGet(t *testing.T) {
+	srv, db := newDB(t)
+	defer srv.Close()
+
+	rev, err := db.Put("a", testDocument{Field: 1}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := db.BulkDocs(
+		testDocument{ID: "a", Rev: rev, Field: 2},
+		testDocument{ID: "b", Field: 3},
+		testDocument{ID: "a", Rev: rev, Field: 4},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "number of results", 3, len(res))
+	check(t, "first ok", true, res[0].OK)
+	check(t, "second ok", true, res[1].OK)
+	check(t, "third error", couchdb.BulkDocsResp{ID: "a", Error: "conflict", Reason: "Document update conflict."}, res[2])
+
+	docs, notFound, err := db.BulkGet([]string{"a", "missing", "b"}, testDocument{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "docs", []interface{}{
+		testDocument{ID: "a", Rev: res[0].Rev, Field: 2},
+		testDocument{ID: "b", Rev: res[1].Rev, Field: 3},
+	}, docs)
+	check(t, "notFound", []string{"missing"}, notFound)
+}
+
+func TestSecurity(t *testing.T) {
+	srv, db := newDB(t)
+	defer srv.Close()
+
+	secobj, err := db.Security()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "default security", &couchdb.Security{}, secobj)
+
+	secobj.Admins.Names = []string{"admin"}
+	secobj.Members.Roles = []string{"reader"}
+	if err := db.PutSecurity(secobj); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.Security()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "security", secobj, got)
+}
+
+func TestNumbersRoundTrip(t *testing.T) {
+	srv, db := newDB(t)
+	defer srv.Close()
+
+	if _, err := db.Put("doc", testDocument{Field: 1<<62 + 1}, ""); err != nil {
+		t.Fatal(err)
+	}
+	var doc testDocument
+	if err := db.Get("doc", &doc, nil); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "field", int64(1<<62+1), doc.Field)
+
+	att := &couchdb.Attachment{Name: "a", Body: bytes.NewReader(nil)}
+	if _, err := db.PutAttachment("doc", att, doc.Rev); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get("doc", &doc, nil); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "field after attachment update", int64(1<<62+1), doc.Field)
+}
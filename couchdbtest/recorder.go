@@ -0,0 +1,333 @@
+package couchdbtest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Mode selects whether a Recorder records or replays requests.
+type Mode int
+
+const (
+	// Replay serves the responses of a fixture file. Requests that are
+	// not in the file fail.
+	Replay Mode = iota
+	// Record sends requests to a real server and captures the requests
+	// and responses, to be written to the fixture file by Close.
+	Record
+)
+
+// Interaction is a request and its response, as stored in fixture files.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is a request in a fixture file. The URL only consists
+// of the path and the query, whose parameters are sorted, so fixtures
+// don't depend on the server address or the order of options. Binary
+// bodies, like those of attachments, are stored in base64.
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+	Base64 bool        `json:"base64,omitempty"`
+}
+
+// RecordedResponse is a response in a fixture file, its body is stored
+// like the one of requests.
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	Base64     bool        `json:"base64,omitempty"`
+}
+
+// Recorder is an http.RoundTripper that records requests to a CouchDB
+// server and their responses in a fixture file, and replays them. Use it
+// as the transport of the *http.Client passed to couchdb.NewClient:
+//
+//	mode := couchdbtest.Replay
+//	if *update {
+//		mode = couchdbtest.Record
+//	}
+//	rec, err := couchdbtest.NewRecorder("testdata/orders.json", mode, nil)
+//	...
+//	client := couchdb.NewClient(addr, &http.Client{Transport: rec}, auth)
+//	...
+//	err = rec.Close()
+//
+// Requests are matched by method, path, query and body. Query parameters
+// and the members of JSON bodies are compared regardless of their order.
+// Identical requests are replayed in the order they were recorded.
+//
+// Credentials are never recorded: the Authorization, Cookie and
+// X-Auth-CouchDB-Token headers are stripped, as are the bodies of
+// /_session requests. Set-Cookie headers are kept with the values of
+// the cookies redacted, so that sessions of CookieAuth can be replayed.
+// Whole response bodies are recorded, so feeds must be bounded, e.g. by
+// using the normal mode or a timeout.
+type Recorder struct {
+	mode      Mode
+	path      string
+	transport http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []*Interaction
+	used         []bool // replayed interactions
+}
+
+// NewRecorder creates a Recorder for a fixture file. In Replay mode, the
+// file is read. In Record mode, requests are sent with transport, or
+// http.DefaultTransport if it is nil.
+func NewRecorder(path string, mode Mode, transport http.RoundTripper) (*Recorder, error) {
+	r := &Recorder{mode: mode, path: path, transport: transport}
+	if r.transport == nil {
+		r.transport = http.DefaultTransport
+	}
+	if mode == Replay {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &r.interactions); err != nil {
+			return nil, fmt.Errorf("couchdbtest: invalid fixture file %s: %v", path, err)
+		}
+		r.used = make([]bool, len(r.interactions))
+	}
+	return r, nil
+}
+
+// Close writes the fixture file in Record mode. In Replay mode, it
+// returns an error if some of the recorded requests were not sent.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.mode == Record {
+		data, err := json.MarshalIndent(r.interactions, "", "  ")
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(r.path, append(data, '\n'), 0644)
+	}
+	var missing []string
+	for i, in := range r.interactions {
+		if !r.used[i] {
+			missing = append(missing, in.Request.Method+" "+in.Request.URL)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("couchdbtest: %d recorded requests were not sent: %s",
+			len(missing), strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	out, body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	recorded := recordRequest(req, body)
+	if r.mode == Replay {
+		return r.replay(req, recorded)
+	}
+
+	resp, err := r.transport.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	in := &Interaction{
+		Request: recorded,
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     stripHeader(resp.Header),
+		},
+	}
+	in.Response.Body, in.Response.Base64 = encodeBody(respBody)
+	r.mu.Lock()
+	r.interactions = append(r.interactions, in)
+	r.mu.Unlock()
+	return resp, nil
+}
+
+func (r *Recorder) replay(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, in := range r.interactions {
+		if r.used[i] || !matches(in.Request, recorded) {
+			continue
+		}
+		r.used[i] = true
+		body, err := decodeBody(in.Response.Body, in.Response.Base64)
+		if err != nil {
+			return nil, fmt.Errorf("couchdbtest: invalid body of recorded response to %s %s: %v",
+				in.Request.Method, in.Request.URL, err)
+		}
+		header := make(http.Header, len(in.Response.Header))
+		for k, vs := range in.Response.Header {
+			header[k] = append([]string(nil), vs...)
+		}
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Response.StatusCode, http.StatusText(in.Response.StatusCode)),
+			StatusCode:    in.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			ContentLength: int64(len(body)),
+			Body:          ioutil.NopCloser(bytes.NewReader(body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("couchdbtest: unexpected request %s %s", recorded.Method, recorded.URL)
+}
+
+// readRequestBody reads the body of a request. As a RoundTripper must not
+// modify the request, it returns a copy of it that can still be sent.
+func readRequestBody(req *http.Request) (*http.Request, []byte, error) {
+	if req.Body == nil {
+		return req, nil, nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	out := req.WithContext(req.Context())
+	out.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return out, body, nil
+}
+
+func recordRequest(req *http.Request, body []byte) RecordedRequest {
+	u := url.URL{
+		Path:     req.URL.Path,
+		RawPath:  req.URL.RawPath,
+		RawQuery: normalizeQuery(req.URL.RawQuery),
+	}
+	rec := RecordedRequest{Method: req.Method, URL: u.String(), Header: stripHeader(req.Header)}
+	if !isSession(req.URL.Path) {
+		rec.Body, rec.Base64 = encodeBody(normalizeBody(body))
+	}
+	return rec
+}
+
+func matches(recorded, req RecordedRequest) bool {
+	if recorded.Method != req.Method || recorded.URL != req.URL {
+		return false
+	}
+	if isSession(recorded.URL) {
+		return true
+	}
+	// Hand-written fixtures may not be normalized.
+	body, err := decodeBody(recorded.Body, recorded.Base64)
+	if err != nil {
+		return false
+	}
+	want, _ := encodeBody(normalizeBody(body))
+	return want == req.Body
+}
+
+// encodeBody returns a body as string, in base64 if it is not UTF-8.
+func encodeBody(body []byte) (string, bool) {
+	if utf8.Valid(body) {
+		return string(body), false
+	}
+	return base64.StdEncoding.EncodeToString(body), true
+}
+
+func decodeBody(body string, isBase64 bool) ([]byte, error) {
+	if isBase64 {
+		return base64.StdEncoding.DecodeString(body)
+	}
+	return []byte(body), nil
+}
+
+func isSession(path string) bool {
+	return strings.HasSuffix(strings.SplitN(path, "?", 2)[0], "/_session")
+}
+
+// normalizeQuery sorts the parameters of a query. It is kept as is if it
+// can't be parsed.
+func normalizeQuery(raw string) string {
+	q, err := url.ParseQuery(raw)
+	if err != nil {
+		return raw
+	}
+	return q.Encode()
+}
+
+// normalizeBody re-encodes JSON bodies, which sorts their members.
+// Other bodies are kept as is.
+func normalizeBody(body []byte) []byte {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return body
+	}
+	normalized, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	return normalized
+}
+
+// strippedHeaders carry credentials or change with every request.
+var strippedHeaders = []string{
+	"Authorization", "Cookie", "X-Auth-Couchdb-Token", "Date",
+}
+
+// redactedCookie replaces the values of recorded cookies.
+const redactedCookie = "redacted"
+
+func stripHeader(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for k, vs := range h {
+		out[k] = append([]string(nil), vs...)
+	}
+	for _, k := range strippedHeaders {
+		out.Del(k)
+	}
+	if cookies := redactCookies(out); len(cookies) > 0 {
+		out["Set-Cookie"] = cookies
+	} else {
+		out.Del("Set-Cookie")
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// redactCookies returns the Set-Cookie headers of h with the values of
+// the cookies replaced, so that replayed sessions still work, but don't
+// give away credentials. Empty values, which end sessions, are kept.
+func redactCookies(h http.Header) []string {
+	var cookies []string
+	for _, c := range (&http.Response{Header: h}).Cookies() {
+		if c.Value != "" {
+			c.Value = redactedCookie
+		}
+		cookies = append(cookies, c.String())
+	}
+	return cookies
+}
@@ -0,0 +1,213 @@
+package couchdbtest_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cabify/go-couchdb"
+	"github.com/cabify/go-couchdb/couchdbtest"
+)
+
+// session runs the requests that are recorded and replayed.
+func session(t *testing.T, c *couchdb.Client) {
+	db, err := c.CreateDB("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev, err := db.Put("doc", testDocument{Field: 1}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Put("doc", testDocument{Field: 2}, rev); err != nil {
+		t.Fatal(err)
+	}
+	var doc testDocument
+	if err := db.Get("doc", &doc, couchdb.Options{"rev": rev, "revs": false, "conflicts": false}); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "doc at first rev", int64(1), doc.Field)
+	if err := db.Get("doc", &doc, nil); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "doc", int64(2), doc.Field)
+	att := &couchdb.Attachment{Name: "bin", Type: "application/octet-stream", Body: bytes.NewReader([]byte{0xff, 0})}
+	if _, err := db.PutAttachment("doc", att, doc.Rev); err != nil {
+		t.Fatal(err)
+	}
+	if att, err = db.Attachment("doc", "bin", ""); err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(att.Body)
+	check(t, "attachment body", []byte{0xff, 0}, body)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "couchdbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fixture := filepath.Join(dir, "fixture.json")
+
+	srv := couchdbtest.NewServer()
+	rec, err := couchdbtest.NewRecorder(fixture, couchdbtest.Record, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(srv.URL)
+	session(t, couchdb.NewClient(u, &http.Client{Transport: rec}, couchdb.BasicAuth("user", "secret")))
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+
+	data, err := ioutil.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "fixture contains credentials", false,
+		bytes.Contains(data, []byte("Authorization")) || bytes.Contains(data, []byte("dXNlcjpzZWNyZXQ=")))
+
+	rec, err = couchdbtest.NewRecorder(fixture, couchdbtest.Replay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ = url.Parse("http://replay.invalid:5984/")
+	c := couchdb.NewClient(u, &http.Client{Transport: rec}, couchdb.BasicAuth("other", "creds"))
+	session(t, c)
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.DB("db").Get("doc", nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "unexpected request GET /db/doc") {
+		t.Errorf("replaying an unexpected request returned %v", err)
+	}
+}
+
+func TestReplayReportsUnsentRequests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "couchdbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fixture := filepath.Join(dir, "fixture.json")
+	err = ioutil.WriteFile(fixture, []byte(`[
+		{"request": {"method": "GET", "url": "/db/_all_docs?include_docs=true&limit=1"},
+		 "response": {"status_code": 200, "body": "{\"total_rows\":0,\"offset\":0,\"rows\":[]}"}},
+		{"request": {"method": "HEAD", "url": "/"},
+		 "response": {"status_code": 200}}
+	]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := couchdbtest.NewRecorder(fixture, couchdbtest.Replay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("http://replay.invalid:5984/")
+	db := couchdb.NewClient(u, &http.Client{Transport: rec}, nil).DB("db")
+	var res couchdb.AllDocsResult
+	if err := db.AllDocs(&res, couchdb.Options{"limit": 1, "include_docs": true}); err != nil {
+		t.Fatal(err)
+	}
+	err = rec.Close()
+	check(t, "Close error", "couchdbtest: 1 recorded requests were not sent: HEAD /", err.Error())
+}
+
+func TestRecordAndReplayCookieAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "couchdbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fixture := filepath.Join(dir, "fixture.json")
+
+	srv := couchdbtest.NewServer()
+	defer srv.Close()
+	// The fake server has no sessions, they are served in front of it.
+	sessions := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_session" {
+			srv.ServeHTTP(w, r)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "AuthSession", Value: "c2VjcmV0", Path: "/", MaxAge: 600, HttpOnly: true})
+		io.WriteString(w, `{"ok":true,"name":"user","roles":[]}`)
+	}))
+	defer sessions.Close()
+	if _, err := srv.Client().CreateDB("db"); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(c *couchdb.Client) error {
+		return c.DB("db").Get("doc", nil, nil)
+	}
+	rec, err := couchdbtest.NewRecorder(fixture, couchdbtest.Record, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(sessions.URL)
+	err = get(couchdb.NewClient(u, &http.Client{Transport: rec}, couchdb.CookieAuth("user", "secret")))
+	check(t, "NotFound(err) when recording", true, couchdb.NotFound(err))
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "fixture contains credentials", false,
+		bytes.Contains(data, []byte("c2VjcmV0")) || bytes.Contains(data, []byte("secret")))
+	check(t, "fixture contains redacted cookie", true,
+		bytes.Contains(data, []byte("AuthSession=redacted; Path=/; Max-Age=600; HttpOnly")))
+
+	rec, err = couchdbtest.NewRecorder(fixture, couchdbtest.Replay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ = url.Parse("http://replay.invalid:5984/")
+	err = get(couchdb.NewClient(u, &http.Client{Transport: rec}, couchdb.CookieAuth("other", "creds")))
+	check(t, "NotFound(err) when replaying", true, couchdb.NotFound(err))
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordKeepsRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		resp.Write(body)
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "couchdbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rec, err := couchdbtest.NewRecorder(filepath.Join(dir, "fixture.json"), couchdbtest.Record, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := ioutil.NopCloser(strings.NewReader(`{"docs":[]}`))
+	req, _ := http.NewRequest("POST", srv.URL+"/db/_bulk_docs", nil)
+	req.Body = body
+	resp, err := rec.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	check(t, "sent body", `{"docs":[]}`, string(sent))
+	check(t, "request body after RoundTrip", body, req.Body)
+}
@@ -0,0 +1,326 @@
+// Package couchdbtest provides an in-memory fake CouchDB server for tests.
+//
+// The server implements the parts of the CouchDB HTTP API that the couchdb
+// package uses: databases, documents with revisions, attachments,
+// _all_docs, _bulk_docs, _bulk_get, _security, views and _changes. Tests
+// can run against a real *couchdb.Client without a CouchDB instance:
+//
+//	srv := couchdbtest.NewServer()
+//	defer srv.Close()
+//	db, err := srv.Client().CreateDB("test")
+//
+// Some simplifications apply. Credentials are not checked, documents have
+// a linear revision history, so there are no conflicting revisions, and
+// views are defined with Go map functions instead of JavaScript.
+//
+// Tests that need a real CouchDB can use a Recorder instead, which records
+// the requests and responses in fixture files and replays them later.
+package couchdbtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cabify/go-couchdb"
+)
+
+// Server is a fake CouchDB server.
+type Server struct {
+	URL string // base URL of the form http://ipaddr:port, without trailing slash
+
+	srv *httptest.Server
+
+	mu    sync.Mutex
+	dbs   map[string]*database
+	views map[string]MapFunc // by "ddoc/view"
+	ids   int                // generated document IDs
+}
+
+// NewServer starts a fake CouchDB server without databases.
+// The caller should call Close when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		dbs:   make(map[string]*database),
+		views: make(map[string]MapFunc),
+	}
+	s.srv = httptest.NewServer(s)
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts down the server and blocks until all outstanding
+// requests on this server have completed. Feeds are ended.
+func (s *Server) Close() {
+	s.mu.Lock()
+	for name, db := range s.dbs {
+		db.drop()
+		delete(s.dbs, name)
+	}
+	s.mu.Unlock()
+	s.srv.Close()
+}
+
+// Client returns a client for the server, without authentication.
+func (s *Server) Client() *couchdb.Client {
+	u, _ := url.Parse(s.URL)
+	return couchdb.NewClient(u, s.srv.Client(), nil)
+}
+
+// MapFunc is the map function of a view. It is called for every document
+// of a database, with _id and _rev set, and calls emit for every row of
+// the view. Design and deleted documents are not mapped. The function must
+// not send requests to the server.
+type MapFunc func(doc map[string]interface{}, emit func(key, value interface{}))
+
+// AddView defines the map function of a view. The ddoc argument is the
+// name of the design document without the _design/ prefix. The view can be
+// queried in every database that has the design document, whatever its
+// content. Reduce functions are not supported.
+func (s *Server) AddView(ddoc, view string, fn MapFunc) {
+	ddoc = strings.TrimPrefix(ddoc, "_design/")
+	s.mu.Lock()
+	s.views[ddoc+"/"+view] = fn
+	s.mu.Unlock()
+}
+
+// couchError is an error response in the format of CouchDB.
+type couchError struct {
+	status int
+	error  string
+	reason string
+}
+
+func (e *couchError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.status, e.error, e.reason)
+}
+
+func badRequest(format string, args ...interface{}) *couchError {
+	return &couchError{http.StatusBadRequest, "bad_request", fmt.Sprintf(format, args...)}
+}
+
+var (
+	errMissing      = &couchError{http.StatusNotFound, "not_found", "missing"}
+	errDeleted      = &couchError{http.StatusNotFound, "not_found", "deleted"}
+	errDBMissing    = &couchError{http.StatusNotFound, "not_found", "Database does not exist."}
+	errDBExists     = &couchError{http.StatusPreconditionFailed, "file_exists", "The database could not be created, the file already exists."}
+	errConflict     = &couchError{http.StatusConflict, "conflict", "Document update conflict."}
+	errNotAllowed   = &couchError{http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed."}
+	errBadJSON      = &couchError{http.StatusBadRequest, "bad_request", "invalid UTF-8 JSON"}
+	errIllegalDB    = &couchError{http.StatusBadRequest, "illegal_database_name", "Name is not a valid database name."}
+	errNoAttachment = &couchError{http.StatusNotFound, "not_found", "Document is missing attachment"}
+)
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, &couchError{http.StatusInternalServerError, "internal_server_error", err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	cerr := asCouchError(err)
+	writeJSON(w, cerr.status, map[string]string{"error": cerr.error, "reason": cerr.reason})
+}
+
+// readJSON decodes the body of a request. Numbers are kept as json.Number
+// so that documents are returned exactly as they were stored.
+func readJSON(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return errBadJSON
+	}
+	return nil
+}
+
+var validDBName = regexp.MustCompile(`^[a-z][a-z0-9_$()+/-]*$`)
+
+// splitPath splits the escaped path of a request into its unescaped
+// segments. The IDs of design and local documents are joined into a
+// single segment, whether their slash was escaped or not.
+func splitPath(escaped string) ([]string, error) {
+	var segs []string
+	for _, seg := range strings.Split(strings.Trim(escaped, "/"), "/") {
+		if seg == "" {
+			continue
+		}
+		seg, err := url.PathUnescape(seg)
+		if err != nil {
+			return nil, badRequest("invalid path: %v", err)
+		}
+		segs = append(segs, seg)
+	}
+	if len(segs) > 2 && (segs[1] == "_design" || segs[1] == "_local") {
+		segs = append([]string{segs[0], segs[1] + "/" + segs[2]}, segs[3:]...)
+	}
+	return segs, nil
+}
+
+// ServeHTTP implements http.Handler, so the server can also be mounted in
+// another server or called from a custom http.RoundTripper.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segs, err := splitPath(r.URL.EscapedPath())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	switch {
+	case len(segs) == 0:
+		s.serveRoot(w, r)
+	case segs[0] == "_all_dbs":
+		s.serveAllDBs(w, r)
+	case segs[0] == "_up":
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	case strings.HasPrefix(segs[0], "_"):
+		writeError(w, errIllegalDB)
+	case len(segs) == 1:
+		s.serveDB(w, r, segs[0])
+	default:
+		s.serveInDB(w, r, segs[0], segs[1:])
+	}
+}
+
+func (s *Server) serveRoot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		writeError(w, errNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"couchdb": "Welcome",
+		"version": "3.3.0",
+		"vendor":  map[string]string{"name": "couchdbtest"},
+	})
+}
+
+func (s *Server) serveAllDBs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		writeError(w, errNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	names := make([]string, 0, len(s.dbs))
+	for name := range s.dbs {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+	sort.Strings(names)
+	writeJSON(w, http.StatusOK, names)
+}
+
+func (s *Server) serveDB(w http.ResponseWriter, r *http.Request, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db := s.dbs[name]
+	switch r.Method {
+	case "PUT":
+		if !validDBName.MatchString(name) {
+			writeError(w, errIllegalDB)
+		} else if db != nil {
+			writeError(w, errDBExists)
+		} else {
+			s.dbs[name] = newDatabase(name)
+			writeJSON(w, http.StatusCreated, map[string]bool{"ok": true})
+		}
+		return
+	}
+	if db == nil {
+		writeError(w, errDBMissing)
+		return
+	}
+	switch r.Method {
+	case "GET", "HEAD":
+		writeJSON(w, http.StatusOK, db.info())
+	case "DELETE":
+		db.drop()
+		delete(s.dbs, name)
+		writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+	case "POST":
+		var doc map[string]interface{}
+		if err := readJSON(r, &doc); err != nil {
+			writeError(w, err)
+			return
+		}
+		id, _ := doc["_id"].(string)
+		if id == "" {
+			id = s.newID()
+		}
+		rev, err := db.put(id, doc, r.URL.Query().Get("rev"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"ok": true, "id": id, "rev": rev})
+	default:
+		writeError(w, errNotAllowed)
+	}
+}
+
+// newID generates a document ID for POST and _bulk_docs requests. The IDs
+// are sequential, so tests are deterministic.
+func (s *Server) newID() string {
+	s.ids++
+	return fmt.Sprintf("%032x", s.ids)
+}
+
+// serveInDB serves the documents and endpoints of a database. The changes
+// feed is served without holding the lock, as it may wait for updates.
+func (s *Server) serveInDB(w http.ResponseWriter, r *http.Request, name string, segs []string) {
+	if segs[0] == "_changes" && len(segs) == 1 {
+		s.serveChanges(w, r, name)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db := s.dbs[name]
+	if db == nil {
+		writeError(w, errDBMissing)
+		return
+	}
+	switch {
+	case segs[0] == "_all_docs":
+		s.serveAllDocs(w, r, db, segs[1:])
+	case segs[0] == "_bulk_docs" && len(segs) == 1:
+		s.serveBulkDocs(w, r, db)
+	case segs[0] == "_bulk_get" && len(segs) == 1:
+		serveBulkGet(w, r, db)
+	case segs[0] == "_security" && len(segs) == 1:
+		serveSecurity(w, r, db)
+	case strings.HasPrefix(segs[0], "_design/") && len(segs) > 2 && segs[1] == "_view":
+		s.serveView(w, r, db, strings.TrimPrefix(segs[0], "_design/"), segs[2:])
+	case strings.HasPrefix(segs[0], "_") &&
+		!strings.HasPrefix(segs[0], "_design/") && !strings.HasPrefix(segs[0], "_local/"):
+		writeError(w, errMissing)
+	case len(segs) == 1:
+		serveDoc(w, r, db, segs[0])
+	default:
+		serveAttachment(w, r, db, segs[0], strings.Join(segs[1:], "/"))
+	}
+}
+
+func serveSecurity(w http.ResponseWriter, r *http.Request, db *database) {
+	switch r.Method {
+	case "GET", "HEAD":
+		writeJSON(w, http.StatusOK, db.security)
+	case "PUT":
+		var secobj map[string]interface{}
+		if err := readJSON(r, &secobj); err != nil {
+			writeError(w, err)
+			return
+		}
+		db.security = secobj
+		writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+	default:
+		writeError(w, errNotAllowed)
+	}
+}
@@ -0,0 +1,69 @@
+package couchdbtest_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cabify/go-couchdb"
+	"github.com/cabify/go-couchdb/couchdbtest"
+)
+
+func check(t *testing.T, field string, expected, actual interface{}) {
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("%s mismatch:\nwant %#v\ngot  %#v", field, expected, actual)
+	}
+}
+
+// newDB starts a server with an empty database named "db".
+// The caller must close the server.
+func newDB(t *testing.T) (*couchdbtest.Server, *couchdb.DB) {
+	srv := couchdbtest.NewServer()
+	db, err := srv.Client().CreateDB("db")
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return srv, db
+}
+
+type testDocument struct {
+	ID    string `json:"_id,omitempty"`
+	Rev   string `json:"_rev,omitempty"`
+	Field int64  `json:"field"`
+}
+
+func TestDatabases(t *testing.T) {
+	srv := couchdbtest.NewServer()
+	defer srv.Close()
+	c := srv.Client()
+
+	if err := c.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateDB("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateDB("a/c"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.CreateDB("b")
+	check(t, "412 for existing database", true, couchdb.ErrorStatus(err, 412))
+	_, err = c.CreateDB("B")
+	check(t, "400 for invalid name", true, couchdb.ErrorStatus(err, 400))
+	if _, err := c.EnsureDB("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := c.AllDBs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "AllDBs", []string{"a/c", "b"}, names)
+
+	if err := c.DeleteDB("b"); err != nil {
+		t.Fatal(err)
+	}
+	err = c.DB("b").Get("doc", nil, nil)
+	check(t, "NotFound(err) for deleted database", true, couchdb.NotFound(err))
+	check(t, "DeleteDB again", true, couchdb.NotFound(c.DeleteDB("b")))
+}
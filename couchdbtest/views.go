@@ -0,0 +1,477 @@
+package couchdbtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonParams are the query parameters that are encoded in JSON.
+var jsonParams = map[string]bool{
+	"key": true, "keys": true,
+	"startkey": true, "start_key": true,
+	"endkey": true, "end_key": true,
+	"doc_ids": true,
+}
+
+// params returns the parameters of a request. The query parameters are
+// merged with the object in the body of POST requests.
+func params(r *http.Request) (map[string]interface{}, error) {
+	p := make(map[string]interface{})
+	for k, vs := range r.URL.Query() {
+		v := vs[len(vs)-1]
+		if !jsonParams[k] {
+			p[k] = v
+			continue
+		}
+		var jv interface{}
+		if err := json.Unmarshal([]byte(v), &jv); err != nil {
+			return nil, badRequest("invalid JSON in parameter %s", k)
+		}
+		p[k] = jv
+	}
+	if r.Method == "POST" {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			return nil, errBadJSON
+		}
+		for k, v := range body {
+			p[k] = v
+		}
+	}
+	return p, nil
+}
+
+func boolParam(v interface{}) (bool, error) {
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case string:
+		return strconv.ParseBool(v)
+	}
+	return false, fmt.Errorf("not a boolean: %v", v)
+}
+
+func intParam(v interface{}) (int, error) {
+	switch v := v.(type) {
+	case float64:
+		return int(v), nil
+	case string:
+		return strconv.Atoi(v)
+	}
+	return 0, fmt.Errorf("not an integer: %v", v)
+}
+
+func stringParam(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return "", fmt.Errorf("not a string: %v", v)
+}
+
+// query holds the parameters of a view or _all_docs query.
+type query struct {
+	keys                 []interface{} // nil if not given
+	start, end           interface{}
+	hasStart, hasEnd     bool
+	startDocID, endDocID string
+	inclusiveEnd         bool
+	descending           bool
+	includeDocs          bool
+	updateSeq            bool
+	limit, skip          int // limit is -1 if not given
+}
+
+func parseQuery(p map[string]interface{}) (*query, error) {
+	q := &query{inclusiveEnd: true, limit: -1}
+	for k, v := range p {
+		var err error
+		switch k {
+		case "keys":
+			var ok bool
+			if q.keys, ok = v.([]interface{}); !ok {
+				err = fmt.Errorf("not an array: %v", v)
+			}
+		case "startkey", "start_key":
+			q.start, q.hasStart = v, true
+		case "endkey", "end_key":
+			q.end, q.hasEnd = v, true
+		case "startkey_docid", "start_key_doc_id":
+			q.startDocID, err = stringParam(v)
+		case "endkey_docid", "end_key_doc_id":
+			q.endDocID, err = stringParam(v)
+		case "inclusive_end":
+			q.inclusiveEnd, err = boolParam(v)
+		case "descending":
+			q.descending, err = boolParam(v)
+		case "include_docs":
+			q.includeDocs, err = boolParam(v)
+		case "update_seq":
+			q.updateSeq, err = boolParam(v)
+		case "limit":
+			q.limit, err = intParam(v)
+		case "skip":
+			q.skip, err = intParam(v)
+		case "reduce", "group":
+			var b bool
+			if b, err = boolParam(v); err == nil && b {
+				return nil, &couchError{http.StatusBadRequest, "query_parse_error", "Reduce is not supported."}
+			}
+		case "group_level":
+			return nil, &couchError{http.StatusBadRequest, "query_parse_error", "Reduce is not supported."}
+		}
+		if err != nil {
+			return nil, &couchError{http.StatusBadRequest, "query_parse_error", fmt.Sprintf("Invalid value for %s: %v", k, err)}
+		}
+	}
+	if key, ok := p["key"]; ok {
+		q.start, q.end, q.hasStart, q.hasEnd = key, key, true, true
+	}
+	return q, nil
+}
+
+type row struct {
+	id    string
+	key   interface{}
+	value interface{}
+	doc   *document
+}
+
+// apply returns the rows selected by the query and their offset. The rows
+// must be sorted by key and ID, cmp compares keys.
+func (q *query) apply(rows []row, cmp func(a, b interface{}) int) ([]row, int) {
+	var selected []row
+	offset := 0
+	if q.keys != nil {
+		for _, key := range q.keys {
+			for _, r := range rows {
+				if cmp(r.key, key) == 0 {
+					selected = append(selected, r)
+				}
+			}
+		}
+	} else {
+		sign := 1
+		if q.descending {
+			sign = -1
+			reversed := make([]row, len(rows))
+			for i, r := range rows {
+				reversed[len(rows)-1-i] = r
+			}
+			rows = reversed
+		}
+		for _, r := range rows {
+			if q.before(r, sign, cmp) {
+				offset++
+				continue
+			}
+			if q.after(r, sign, cmp) {
+				break
+			}
+			selected = append(selected, r)
+		}
+	}
+	skip := q.skip
+	if skip > len(selected) {
+		skip = len(selected)
+	}
+	selected, offset = selected[skip:], offset+skip
+	if q.limit >= 0 && q.limit < len(selected) {
+		selected = selected[:q.limit]
+	}
+	return selected, offset
+}
+
+func (q *query) before(r row, sign int, cmp func(a, b interface{}) int) bool {
+	if !q.hasStart {
+		return false
+	}
+	c := sign * cmp(r.key, q.start)
+	return c < 0 || c == 0 && q.startDocID != "" && sign*strings.Compare(r.id, q.startDocID) < 0
+}
+
+func (q *query) after(r row, sign int, cmp func(a, b interface{}) int) bool {
+	if !q.hasEnd {
+		return false
+	}
+	c := sign * cmp(r.key, q.end)
+	if c == 0 && q.endDocID != "" {
+		c = sign * strings.Compare(r.id, q.endDocID)
+	}
+	return c > 0 || c == 0 && !q.inclusiveEnd
+}
+
+// result builds the response of a query from the selected rows.
+func (q *query) result(db *database, rows []row, total, offset int) map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(rows))
+	for _, r := range rows {
+		m := map[string]interface{}{"id": r.id, "key": r.key, "value": r.value}
+		if q.includeDocs {
+			m["doc"] = r.doc.json(r.doc.current(), false)
+		}
+		out = append(out, m)
+	}
+	res := map[string]interface{}{"total_rows": total, "offset": offset, "rows": out}
+	if q.updateSeq {
+		res["update_seq"] = seqString(db.seq)
+	}
+	return res
+}
+
+// serveQuery serves a single query, or several queries if the last
+// segment of the path is "queries".
+func serveQuery(w http.ResponseWriter, r *http.Request, multi bool, run func(map[string]interface{}) (interface{}, error)) {
+	if multi {
+		if r.Method != "POST" {
+			writeError(w, errNotAllowed)
+			return
+		}
+		var req struct {
+			Queries []map[string]interface{} `json:"queries"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, errBadJSON)
+			return
+		}
+		results := make([]interface{}, 0, len(req.Queries))
+		for _, p := range req.Queries {
+			res, err := run(p)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			results = append(results, res)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" && r.Method != "POST" {
+		writeError(w, errNotAllowed)
+		return
+	}
+	p, err := params(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res, err := run(p)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) serveAllDocs(w http.ResponseWriter, r *http.Request, db *database, segs []string) {
+	if len(segs) > 1 || len(segs) == 1 && segs[0] != "queries" {
+		writeError(w, errMissing)
+		return
+	}
+	serveQuery(w, r, len(segs) == 1, func(p map[string]interface{}) (interface{}, error) {
+		q, err := parseQuery(p)
+		if err != nil {
+			return nil, err
+		}
+		return allDocs(db, q), nil
+	})
+}
+
+func allDocs(db *database, q *query) map[string]interface{} {
+	var rows []row
+	for id, doc := range db.docs {
+		if !doc.local() && !doc.current().deleted {
+			rows = append(rows, row{id: id, key: id, value: map[string]string{"rev": doc.current().rev}, doc: doc})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].id < rows[j].id })
+	if q.keys == nil {
+		selected, offset := q.apply(rows, rawCollate)
+		return q.result(db, selected, len(rows), offset)
+	}
+
+	// Rows of keys are sent even for deleted and missing documents.
+	out := make([]map[string]interface{}, 0, len(q.keys))
+	for _, key := range q.keys {
+		id, _ := key.(string)
+		doc := db.docs[id]
+		switch {
+		case doc == nil || doc.local():
+			out = append(out, map[string]interface{}{"key": key, "error": "not_found"})
+		case doc.current().deleted:
+			m := map[string]interface{}{
+				"id": id, "key": key,
+				"value": map[string]interface{}{"rev": doc.current().rev, "deleted": true},
+			}
+			if q.includeDocs {
+				m["doc"] = nil
+			}
+			out = append(out, m)
+		default:
+			m := map[string]interface{}{"id": id, "key": key, "value": map[string]string{"rev": doc.current().rev}}
+			if q.includeDocs {
+				m["doc"] = doc.json(doc.current(), false)
+			}
+			out = append(out, m)
+		}
+	}
+	res := map[string]interface{}{"total_rows": len(rows), "offset": 0, "rows": out}
+	if q.updateSeq {
+		res["update_seq"] = seqString(db.seq)
+	}
+	return res
+}
+
+func (s *Server) serveView(w http.ResponseWriter, r *http.Request, db *database, ddoc string, segs []string) {
+	if len(segs) > 2 || len(segs) == 2 && segs[1] != "queries" {
+		writeError(w, errMissing)
+		return
+	}
+	if _, _, err := db.lookup("_design/"+ddoc, ""); err != nil {
+		writeError(w, err)
+		return
+	}
+	fn := s.views[ddoc+"/"+segs[0]]
+	if fn == nil {
+		writeError(w, &couchError{http.StatusNotFound, "not_found", "missing_named_view"})
+		return
+	}
+	rows := mapDocs(db, fn)
+	serveQuery(w, r, len(segs) == 2, func(p map[string]interface{}) (interface{}, error) {
+		q, err := parseQuery(p)
+		if err != nil {
+			return nil, err
+		}
+		selected, offset := q.apply(rows, collate)
+		return q.result(db, selected, len(rows), offset), nil
+	})
+}
+
+// mapDocs calls a map function with all documents of a database and
+// returns the emitted rows, sorted by key and ID.
+func mapDocs(db *database, fn MapFunc) []row {
+	var rows []row
+	for id, doc := range db.docs {
+		if doc.local() || strings.HasPrefix(id, "_design/") || doc.current().deleted {
+			continue
+		}
+		var m interface{}
+		plain(doc.json(doc.current(), false), &m)
+		fn(m.(map[string]interface{}), func(key, value interface{}) {
+			r := row{id: id, doc: doc}
+			plain(key, &r.key)
+			plain(value, &r.value)
+			rows = append(rows, r)
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if c := collate(rows[i].key, rows[j].key); c != 0 {
+			return c < 0
+		}
+		return rows[i].id < rows[j].id
+	})
+	return rows
+}
+
+// plain converts v to the plain JSON types of encoding/json, so that the
+// values can be collated. Values that can't be encoded become null.
+func plain(v interface{}, out *interface{}) {
+	b, err := json.Marshal(v)
+	if err == nil {
+		err = json.Unmarshal(b, out)
+	}
+	if err != nil {
+		*out = nil
+	}
+}
+
+// rawCollate compares the keys of _all_docs, which are document IDs, by
+// their bytes.
+func rawCollate(a, b interface{}) int {
+	as, aok := a.(string)
+	bs, bok := b.(string)
+	if aok && bok {
+		return strings.Compare(as, bs)
+	}
+	return collate(a, b)
+}
+
+// collate compares JSON values in the order of CouchDB views: null, false,
+// true, numbers, strings, arrays and objects. Strings are compared without
+// case first, then lowercase before uppercase, which approximates the ICU
+// collation of CouchDB. Objects are compared by their sorted members.
+func collate(a, b interface{}) int {
+	if ra, rb := collationRank(a), collationRank(b); ra != rb {
+		return ra - rb
+	}
+	switch a := a.(type) {
+	case float64:
+		b := b.(float64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	case string:
+		b := b.(string)
+		if c := strings.Compare(strings.ToLower(a), strings.ToLower(b)); c != 0 {
+			return c
+		}
+		return -strings.Compare(a, b)
+	case []interface{}:
+		b := b.([]interface{})
+		for i := 0; i < len(a) && i < len(b); i++ {
+			if c := collate(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+		return len(a) - len(b)
+	case map[string]interface{}:
+		b := b.(map[string]interface{})
+		ak, bk := sortedKeys(a), sortedKeys(b)
+		for i := 0; i < len(ak) && i < len(bk); i++ {
+			if c := collate(ak[i], bk[i]); c != 0 {
+				return c
+			}
+			if c := collate(a[ak[i]], b[bk[i]]); c != 0 {
+				return c
+			}
+		}
+		return len(ak) - len(bk)
+	}
+	return 0
+}
+
+func collationRank(v interface{}) int {
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case bool:
+		if v {
+			return 2
+		}
+		return 1
+	case float64:
+		return 3
+	case string:
+		return 4
+	case []interface{}:
+		return 5
+	case map[string]interface{}:
+		return 6
+	}
+	return 7
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
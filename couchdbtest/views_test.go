@@ -0,0 +1,118 @@
+package couchdbtest_test
+
+import (
+	"testing"
+
+	"github.com/cabify/go-couchdb"
+)
+
+func TestView(t *testing.T) {
+	srv, db := newDB(t)
+	defer srv.Close()
+	srv.AddView("test", "byfield", func(doc map[string]interface{}, emit func(key, value interface{})) {
+		if f, ok := doc["field"].(float64); ok {
+			emit([]interface{}{int(f) % 2, f}, nil)
+		}
+	})
+
+	for i, id := range []string{"d", "c", "b", "a"} {
+		if _, err := db.Put(id, testDocument{Field: int64(i)}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := db.QueryView("test", "byfield", &couchdb.ViewQuery{}, new(couchdb.ViewResult))
+	check(t, "NotFound(err) without design document", true, couchdb.NotFound(err))
+	if err := db.SyncDesign(couchdb.NewDesign("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	var res couchdb.ViewResult
+	if err := db.QueryView("test", "byfield", &couchdb.ViewQuery{}, &res); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "total rows", 4, res.TotalRows)
+	check(t, "ids", []string{"d", "b", "c", "a"}, rowIDs(res))
+
+	q := &couchdb.ViewQuery{
+		StartKey:    []interface{}{1},
+		EndKey:      []interface{}{1, map[string]interface{}{}},
+		IncludeDocs: true,
+	}
+	if err := db.QueryView("test", "byfield", q, &res); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "ids in key range", []string{"c", "a"}, rowIDs(res))
+	check(t, "offset", 2, res.Offset)
+	var docs []testDocument
+	if err := res.DecodeDocs(&docs); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "fields of docs", []int64{1, 3}, []int64{docs[0].Field, docs[1].Field})
+
+	q = &couchdb.ViewQuery{Descending: true, Skip: 1, Limit: 2}
+	if err := db.QueryView("test", "byfield", q, &res); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "ids descending", []string{"c", "b"}, rowIDs(res))
+
+	q = &couchdb.ViewQuery{Keys: []interface{}{[]interface{}{1, 3}, []interface{}{0, 0}}}
+	if err := db.QueryView("test", "byfield", q, &res); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "ids by keys", []string{"a", "d"}, rowIDs(res))
+
+	results, err := db.ViewQueries("test", "byfield", []couchdb.ViewQuery{{Limit: 1}, {Key: []interface{}{1, 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "number of results", 2, len(results))
+	check(t, "ids of queries", [][]string{{"d"}, {"c"}}, [][]string{rowIDs(results[0]), rowIDs(results[1])})
+
+	err = db.QueryView("test", "missing", &couchdb.ViewQuery{}, &res)
+	check(t, "NotFound(err) for missing view", true, couchdb.NotFound(err))
+}
+
+func rowIDs(res couchdb.ViewResult) []string {
+	ids := []string{}
+	for _, row := range res.Rows {
+		ids = append(ids, row.ID)
+	}
+	return ids
+}
+
+func TestAllDocs(t *testing.T) {
+	srv, db := newDB(t)
+	defer srv.Close()
+
+	var revs []string
+	for _, id := range []string{"c", "b", "a", "_design/x"} {
+		rev, err := db.Put(id, testDocument{}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		revs = append(revs, rev)
+	}
+	if _, err := db.Delete("b", revs[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	var res couchdb.AllDocsResult
+	if err := db.QueryAllDocs(&couchdb.ViewQuery{StartKey: "a", EndKey: "c", InclusiveEnd: new(bool)}, &res); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "total rows", 3, res.TotalRows)
+	check(t, "number of rows", 1, len(res.Rows))
+	check(t, "row", "a", res.Rows[0].ID)
+	check(t, "rev", revs[2], res.Rows[0].Value.Rev)
+
+	if err := db.QueryAllDocs(&couchdb.ViewQuery{Keys: []interface{}{"c", "b", "x"}, IncludeDocs: true}, &res); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "Missing", []string{"x"}, res.Missing())
+	check(t, "Deleted", []string{"b"}, res.Deleted())
+	var docs []testDocument
+	if err := res.DecodeDocs(&docs); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "docs", []testDocument{{ID: "c", Rev: revs[0]}}, docs)
+}
@@ -51,11 +51,20 @@ var getJsonKeys = []string{"open_revs", "atts_since"}
 //
 // http://docs.couchdb.org/en/latest/api/document/common.html?highlight=doc#get--db-docid
 func (db *DB) Get(id string, doc interface{}, opts Options) error {
+	return db.get(id, doc, opts)
+}
+
+// GetOrdered is like Get, but encodes the options in the given order.
+func (db *DB) GetOrdered(id string, doc interface{}, opts OrderedOptions) error {
+	return db.get(id, doc, opts)
+}
+
+func (db *DB) get(id string, doc interface{}, opts QueryOptions) error {
 	path, err := optpath(opts, getJsonKeys, db.name, id)
 	if err != nil {
 		return err
 	}
-	resp, err := db.request(db.ctx, "GET", path, nil)
+	resp, err := db.request(db.opctx("Get"), "GET", path, nil)
 	if err != nil {
 		return err
 	}
@@ -67,6 +76,16 @@ func (db *DB) Get(id string, doc interface{}, opts Options) error {
 // It returns the list of found docs as a []interface{}, the list of docs not found as a []string and an eventual error.
 // The found docs should be casted to the same type of docType.
 func (db *DB) BulkGet(ids []string, docType interface{}, opts Options) (docs []interface{}, notFound []string, err error) {
+	return db.bulkGet(ids, docType, opts)
+}
+
+// BulkGetOrdered is like BulkGet, but encodes the options in the given
+// order.
+func (db *DB) BulkGetOrdered(ids []string, docType interface{}, opts OrderedOptions) (docs []interface{}, notFound []string, err error) {
+	return db.bulkGet(ids, docType, opts)
+}
+
+func (db *DB) bulkGet(ids []string, docType interface{}, opts QueryOptions) (docs []interface{}, notFound []string, err error) {
 	path, err := optpath(opts, getJsonKeys, db.name, "_bulk_get")
 	if err != nil {
 		return nil, nil, err
@@ -80,7 +99,7 @@ func (db *DB) BulkGet(ids []string, docType interface{}, opts Options) (docs []i
 	bodyJson, err := json.Marshal(request)
 	body := bytes.NewReader(bodyJson)
 
-	resp, err := db.request(db.ctx, "POST", path, body)
+	resp, err := db.request(db.opctx("BulkGet"), "POST", path, body)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -118,7 +137,7 @@ func (db *DB) BulkGet(ids []string, docType interface{}, opts Options) (docs []i
 // It is faster than an equivalent Get request because no body
 // has to be parsed.
 func (db *DB) Rev(id string) (string, error) {
-	return responseRev(db.closedRequest(db.ctx, "HEAD", path(db.name, id), nil))
+	return responseRev(db.closedRequest(db.opctx("Rev"), "HEAD", path(db.name, id), nil))
 }
 
 // Post stores a new document into the given database.
@@ -130,7 +149,7 @@ func (db *DB) Post(doc interface{}) (id, rev string, err error) {
 		return "", "", err
 	}
 	b := bytes.NewReader(json)
-	resp, err := db.request(db.ctx, "POST", path, b)
+	resp, err := db.request(db.opctx("Post"), "POST", path, b)
 	if err != nil {
 		return "", "", err
 	}
@@ -146,7 +165,7 @@ func (db *DB) Put(id string, doc interface{}, rev string) (newrev string, err er
 		return "", err
 	}
 	b := bytes.NewReader(json)
-	return responseRev(db.closedRequest(db.ctx, "PUT", path, b))
+	return responseRev(db.closedRequest(db.opctx("Put"), "PUT", path, b))
 }
 
 // BulkDocs allows to create, update and/or delete multiple documents in a single request.
@@ -181,7 +200,10 @@ func (db *DB) BulkDocs(docs ...interface{}) (res []BulkDocsResp, err error) {
 		return nil, err
 	}
 	body := bytes.NewReader(bodyJSON)
-	httpResp, err := db.request(db.ctx, http.MethodPost, path, body)
+	httpResp, err := db.request(db.opctx("BulkDocs"), http.MethodPost, path, body)
+	if err != nil {
+		return nil, err
+	}
 
 	err = readBody(httpResp, &res)
 	if err != nil {
@@ -194,7 +216,7 @@ func (db *DB) BulkDocs(docs ...interface{}) (res []BulkDocsResp, err error) {
 // Delete marks a document revision as deleted.
 func (db *DB) Delete(id, rev string) (newrev string, err error) {
 	path := revpath(rev, db.name, id)
-	return responseRev(db.closedRequest(db.ctx, "DELETE", path, nil))
+	return responseRev(db.closedRequest(db.opctx("Delete"), "DELETE", path, nil))
 }
 
 // Security represents database security objects.
@@ -212,12 +234,13 @@ type Members struct {
 // Security retrieves the security object of a database.
 func (db *DB) Security() (*Security, error) {
 	secobj := new(Security)
-	resp, err := db.request(db.ctx, "GET", path(db.name, "_security"), nil)
+	resp, err := db.request(db.opctx("Security"), "GET", path(db.name, "_security"), nil)
 	if err != nil {
 		return nil, err
 	}
 	if resp.ContentLength == 0 {
 		// empty reply means defaults
+		resp.Body.Close()
 		return secobj, nil
 	}
 	if err = readBody(resp, secobj); err != nil {
@@ -230,7 +253,7 @@ func (db *DB) Security() (*Security, error) {
 func (db *DB) PutSecurity(secobj *Security) error {
 	json, _ := json.Marshal(secobj)
 	body := bytes.NewReader(json)
-	_, err := db.request(db.ctx, "PUT", path(db.name, "_security"), body)
+	_, err := db.closedRequest(db.opctx("PutSecurity"), "PUT", path(db.name, "_security"), body)
 	return err
 }
 
@@ -247,12 +270,21 @@ var viewJsonKeys = []string{"startkey", "start_key", "key", "endkey", "end_key",
 //
 // http://docs.couchdb.org/en/latest/api/ddoc/views.html
 func (db *DB) View(ddoc, view string, result interface{}, opts Options) error {
+	return db.view(ddoc, view, result, opts)
+}
+
+// ViewOrdered is like View, but encodes the options in the given order.
+func (db *DB) ViewOrdered(ddoc, view string, result interface{}, opts OrderedOptions) error {
+	return db.view(ddoc, view, result, opts)
+}
+
+func (db *DB) view(ddoc, view string, result interface{}, opts QueryOptions) error {
 	ddoc = strings.Replace(ddoc, "_design/", "", 1)
 	path, err := optpath(opts, viewJsonKeys, db.name, "_design", ddoc, "_view", view)
 	if err != nil {
 		return err
 	}
-	resp, err := db.request(db.ctx, "GET", path, nil)
+	resp, err := db.request(db.viewctx("View", ddoc, view), "GET", path, nil)
 	if err != nil {
 		return err
 	}
@@ -278,6 +310,16 @@ func (db *DB) View(ddoc, view string, result interface{}, opts Options) error {
 //
 // http://docs.couchdb.org/en/latest/api/ddoc/views.html
 func (db *DB) PostView(ddoc, view string, result interface{}, opts Options, payload Payload) error {
+	return db.postView(ddoc, view, result, opts, payload)
+}
+
+// PostViewOrdered is like PostView, but encodes the options in the given
+// order.
+func (db *DB) PostViewOrdered(ddoc, view string, result interface{}, opts OrderedOptions, payload Payload) error {
+	return db.postView(ddoc, view, result, opts, payload)
+}
+
+func (db *DB) postView(ddoc, view string, result interface{}, opts QueryOptions, payload Payload) error {
 	ddoc = strings.Replace(ddoc, "_design/", "", 1)
 	path, err := optpath(opts, viewJsonKeys, db.name, "_design", ddoc, "_view", view)
 	if err != nil {
@@ -288,7 +330,7 @@ func (db *DB) PostView(ddoc, view string, result interface{}, opts Options, payl
 		return err
 	}
 	body := bytes.NewReader(json)
-	resp, err := db.request(db.ctx, "POST", path, body)
+	resp, err := db.request(db.viewctx("PostView", ddoc, view), "POST", path, body)
 	if err != nil {
 		return err
 	}
@@ -306,6 +348,16 @@ func (db *DB) PostView(ddoc, view string, result interface{}, opts Options, payl
 //
 // https://docs.couchdb.org/en/stable/ddocs/search.html
 func (db *DB) PostSearchIndex(ddoc, index string, result interface{}, opts Options, payload Payload) error {
+	return db.postSearchIndex(ddoc, index, result, opts, payload)
+}
+
+// PostSearchIndexOrdered is like PostSearchIndex, but encodes the options
+// in the given order.
+func (db *DB) PostSearchIndexOrdered(ddoc, index string, result interface{}, opts OrderedOptions, payload Payload) error {
+	return db.postSearchIndex(ddoc, index, result, opts, payload)
+}
+
+func (db *DB) postSearchIndex(ddoc, index string, result interface{}, opts QueryOptions, payload Payload) error {
 	ddoc = strings.Replace(ddoc, "_design/", "", 1)
 	path, err := optpath(opts, viewJsonKeys, db.name, "_design", ddoc, "_search", index)
 	if err != nil {
@@ -316,7 +368,7 @@ func (db *DB) PostSearchIndex(ddoc, index string, result interface{}, opts Optio
 		return err
 	}
 	body := bytes.NewReader(json)
-	resp, err := db.request(db.ctx, "POST", path, body)
+	resp, err := db.request(db.viewctx("PostSearchIndex", ddoc, index), "POST", path, body)
 	if err != nil {
 		return err
 	}
@@ -332,11 +384,42 @@ func (db *DB) PostSearchIndex(ddoc, index string, result interface{}, opts Optio
 //
 // http://docs.couchdb.org/en/latest/api/database/bulk-api.html#db-all-docs
 func (db *DB) AllDocs(result interface{}, opts Options) error {
+	return db.allDocs(result, opts)
+}
+
+// AllDocsOrdered is like AllDocs, but encodes the options in the given
+// order.
+func (db *DB) AllDocsOrdered(result interface{}, opts OrderedOptions) error {
+	return db.allDocs(result, opts)
+}
+
+func (db *DB) allDocs(result interface{}, opts QueryOptions) error {
 	path, err := optpath(opts, viewJsonKeys, db.name, "_all_docs")
 	if err != nil {
 		return err
 	}
-	resp, err := db.request(db.ctx, "GET", path, nil)
+	resp, err := db.request(db.opctx("AllDocs"), "GET", path, nil)
+	if err != nil {
+		return err
+	}
+	return readBody(resp, &result)
+}
+
+// PostAllDocs invokes the _all_docs view of a database like AllDocs, but
+// allows for the keys to be supplied in the body of the POST request.
+//
+// http://docs.couchdb.org/en/latest/api/database/bulk-api.html#post--db-_all_docs
+func (db *DB) PostAllDocs(result interface{}, opts QueryOptions, payload Payload) error {
+	path, err := optpath(opts, viewJsonKeys, db.name, "_all_docs")
+	if err != nil {
+		return err
+	}
+	json, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	body := bytes.NewReader(json)
+	resp, err := db.request(db.opctx("PostAllDocs"), "POST", path, body)
 	if err != nil {
 		return err
 	}
@@ -1,10 +1,13 @@
 package couchdb
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"time"
 )
 
 // DBUpdatesFeed is an iterator for the _db_updates feed.
@@ -24,32 +27,108 @@ type DBUpdatesFeed struct {
 	OK    bool   `json:"ok"`      // Event operation status
 	DB    string `json:"db_name"` // Event database name
 
-	end  bool
-	err  error
-	conn io.Closer
-	dec  *json.Decoder
+	// Seq is the update sequence of the current event. CouchDB sends it
+	// since 2.0, it can be passed as "since" option to resume the feed.
+	// After all events have been processed, set to the last_seq value
+	// sent by CouchDB, if any.
+	Seq interface{} `json:"seq"`
+
+	// LastSeq is the last sequence of a normal or longpoll feed, or of a
+	// continuous feed that timed out.
+	LastSeq interface{} `json:"last_seq"`
+
+	end     bool
+	err     error
+	conn    io.Closer
+	dec     *json.Decoder
+	results resultsParser // normal and longpoll feeds only
+	parser  func() error
+	rc      *reconnector // only set for resilient feeds
 }
 
 // DBUpdates opens the _db_updates feed.
-// For the possible options, please see the CouchDB documentation.
-// Pleas note that the "feed" option is currently always set to "continuous".
+// The "feed" option defaults to "continuous". With "normal", the events
+// since the "since" option are returned in a single batch, which allows
+// a restarted watcher to catch up before it follows the feed again.
+// For the other options, please see the CouchDB documentation.
 //
 // http://docs.couchdb.org/en/latest/api/server/common.html#db-updates
 func (c *Client) DBUpdates(options Options) (*DBUpdatesFeed, error) {
-	newopts := options.clone()
-	newopts["feed"] = "continuous"
-	path, err := optpath(newopts, nil, "_db_updates")
+	return c.dbUpdates(options)
+}
+
+// DBUpdatesOrdered is like DBUpdates, but encodes the options in the
+// given order.
+func (c *Client) DBUpdatesOrdered(options OrderedOptions) (*DBUpdatesFeed, error) {
+	return c.dbUpdates(options)
+}
+
+func (c *Client) dbUpdates(options QueryOptions) (*DBUpdatesFeed, error) {
+	mode := "continuous"
+	if m, ok := getOption(options, "feed"); ok {
+		if mode, ok = m.(string); !ok {
+			return nil, fmt.Errorf("couchdb: invalid feed option of type %T", m)
+		}
+	}
+	switch mode {
+	case "normal", "longpoll", "continuous":
+	default:
+		return nil, fmt.Errorf("couchdb: unsupported feed mode %q", mode)
+	}
+	path, err := optpath(withOptions(options, Option{"feed", mode}), nil, "_db_updates")
 	if err != nil {
 		return nil, err
 	}
-	resp, err := c.request(c.ctx, "GET", path, nil)
+	resp, err := c.request(c.opctx("DBUpdates", ""), "GET", path, nil)
 	if err != nil {
 		return nil, err
 	}
-	feed := &DBUpdatesFeed{
-		conn: resp.Body,
-		dec:  json.NewDecoder(resp.Body),
+	return newDBUpdatesFeed(mode, resp.Body), nil
+}
+
+func newDBUpdatesFeed(mode string, conn io.ReadCloser) *DBUpdatesFeed {
+	feed := &DBUpdatesFeed{conn: conn, dec: json.NewDecoder(conn)}
+	if mode == "continuous" {
+		feed.parser = feed.parseContinuous
+	} else {
+		feed.parser = feed.parseResults
 	}
+	return feed
+}
+
+// ResilientDBUpdates opens a continuous _db_updates feed that survives
+// connection failures in the same way as ResilientChanges. After a
+// reconnect, the feed resumes with since set to the Seq of the last event,
+// so no event is missed on CouchDB 2.0 and later.
+// The "since" option can be used to resume from an earlier run.
+func (c *Client) ResilientDBUpdates(options QueryOptions, ropts *ReconnectOptions) (*DBUpdatesFeed, error) {
+	rc := newReconnector(c.ctx, ropts)
+	reopen := func(since interface{}) (io.ReadCloser, error) {
+		opts := withOptions(options,
+			Option{"feed", "continuous"},
+			Option{"heartbeat", int64(rc.opts.Heartbeat / time.Millisecond)})
+		if since != nil {
+			opts = withOptions(opts, Option{"since", since})
+		}
+		path, err := optpath(opts, nil, "_db_updates")
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.request(c.opctx("ResilientDBUpdates", ""), "GET", path, nil)
+		if err != nil {
+			return nil, err
+		}
+		return newStallReader(resp.Body, rc.opts.StallTimeout), nil
+	}
+
+	rc.since, _ = getOption(options, "since")
+	rc.reopen = reopen
+	conn, err := reopen(rc.since)
+	if err != nil {
+		return nil, err
+	}
+	feed := newDBUpdatesFeed("continuous", conn)
+	feed.rc = rc
 	return feed, nil
 }
 
@@ -60,15 +139,55 @@ func (f *DBUpdatesFeed) Next() bool {
 		return false
 	}
 	f.Event, f.DB, f.OK = "", "", false
-	if f.err = f.dec.Decode(f); f.err != nil {
-		if f.err == io.EOF {
-			f.err = nil
-		}
+	if f.rc != nil {
+		f.err = f.rc.next(f.parser, f.replaceConn)
+	} else {
+		f.err = f.parser()
+	}
+	if f.err != nil || f.end {
 		f.Close()
+	} else if f.rc != nil {
+		f.rc.success(f.Seq)
 	}
 	return !f.end
 }
 
+// replaceConn replaces the connection of a resilient feed.
+func (f *DBUpdatesFeed) replaceConn(conn io.ReadCloser) {
+	f.conn.Close()
+	f.conn, f.dec = conn, json.NewDecoder(conn)
+}
+
+// parseContinuous reads an event of a continuous feed, one JSON object
+// per line. A line with only last_seq ends the feed.
+func (f *DBUpdatesFeed) parseContinuous() error {
+	if err := f.dec.Decode(f); err != nil {
+		if err == io.EOF && f.rc == nil {
+			f.end = true
+			return nil
+		}
+		return err
+	}
+	if f.DB == "" && f.LastSeq != nil {
+		f.Seq, f.end = f.LastSeq, true
+	}
+	return nil
+}
+
+// parseResults reads an event of a normal or longpoll feed.
+func (f *DBUpdatesFeed) parseResults() error {
+	end, err := f.results.next(f.dec, f, func(name string) interface{} {
+		if name == "last_seq" {
+			return &f.LastSeq
+		}
+		return nil
+	})
+	if end {
+		f.Seq, f.end = f.LastSeq, true
+	}
+	return err
+}
+
 // Err returns the last error that occurred during iteration.
 func (f *DBUpdatesFeed) Err() error {
 	return f.err
@@ -77,6 +196,9 @@ func (f *DBUpdatesFeed) Err() error {
 // Close terminates the connection of a feed.
 func (f *DBUpdatesFeed) Close() error {
 	f.end = true
+	if f.rc != nil {
+		f.rc.close()
+	}
 	return f.conn.Close()
 }
 
@@ -111,28 +233,90 @@ type ChangesFeed struct {
 	// LastSeq last change sequence number
 	LastSeq interface{} `json:"last_seq"`
 
-	// Changes is the list of the document's leaf revisions.
-	/*
-		Changes []struct {
-			Rev string `json:"rev"`
-		} `json:"changes"`
-	*/
+	// Changes is the list of the document's leaf revisions. Only the
+	// winning revision is listed, unless the feed option "style" is
+	// "all_docs".
+	Changes []ChangeRev `json:"changes"`
 
 	// The document. This is populated only if the feed option
 	// "include_docs" is true.
 	Doc json.RawMessage `json:"doc"`
 
+	// Rev, Conflicts and Attachments are read from Doc. Conflicts requires
+	// the feed option "conflicts", Attachments are stubs unless the feed
+	// option "attachments" is true.
+	Rev         string                    `json:"-"`
+	Conflicts   []string                  `json:"-"`
+	Attachments map[string]AttachmentInfo `json:"-"`
+
+	// Pending is the number of changes left after the last event of a
+	// normal or longpoll feed, if CouchDB reports it.
+	Pending int64 `json:"pending"`
+
 	end     bool
 	err     error
 	conn    io.Closer
 	decoder *json.Decoder
+	reader  *bufio.Reader // eventsource feeds only
+	results resultsParser // normal and longpoll feeds only
 	parser  func() error
+	rc      *reconnector // only set for resilient feeds
+}
+
+// Changes opens the _changes feed of a database.
+// The feed mode is taken from the "feed" option:
+//
+//   - "normal", the default, returns all changes since the "since" option
+//     in a single batch.
+//   - "longpoll" works like "normal", but waits for at least one change.
+//   - "continuous" keeps the connection open and receives an event
+//     whenever a document is created, updated or deleted.
+//   - "eventsource" works like "continuous", but CouchDB sends the events
+//     as Server-Sent Events.
+//
+// The options are not modified, so they can be shared between goroutines.
+// For information on all other options, see the official CouchDB
+// documentation:
+//
+// http://docs.couchdb.org/en/latest/api/database/changes.html#db-changes
+func (db *DB) Changes(options QueryOptions) (*ChangesFeed, error) {
+	return db.changes("GET", options, nil)
+}
+
+// ChangesWithBody opens a changes feed like Changes, but uses a POST
+// that includes a JSON payload of the provided body.
+func (db *DB) ChangesWithBody(options QueryOptions, body interface{}) (*ChangesFeed, error) {
+	json, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	b := bytes.NewReader(json)
+	return db.changes("POST", options, b)
+}
+
+// ChangeRev is a leaf revision listed in a _changes feed event.
+type ChangeRev struct {
+	Rev string `json:"rev"`
+}
+
+// AttachmentInfo describes an attachment included in a document.
+// Data is only set if the attachment body was requested.
+type AttachmentInfo struct {
+	ContentType   string `json:"content_type"`
+	Digest        string `json:"digest"`
+	Length        int64  `json:"length"`
+	RevPos        int    `json:"revpos"`
+	Stub          bool   `json:"stub,omitempty"`
+	Data          []byte `json:"data,omitempty"`
+	Encoding      string `json:"encoding,omitempty"`
+	EncodedLength int64  `json:"encoded_length,omitempty"`
 }
 
 // ContinuousChanges opens the _changes feed of a database for continuous feed updates.
 // This feed receives an event whenever a document is created, updated or deleted.
 //
-// This implementation only continuous feeds.
+// The "feed" option is always set to "continuous", use Changes for the
+// other feed modes.
 //
 // There are many other options that allow you to customize what the
 // feed returns. For information on all of them, see the official CouchDB
@@ -143,9 +327,25 @@ func (db *DB) ContinuousChanges(options Options) (*ChangesFeed, error) {
 	return db.continuousChanges("GET", options, nil)
 }
 
+// ContinuousChangesOrdered is like ContinuousChanges, but encodes the
+// options in the given order.
+func (db *DB) ContinuousChangesOrdered(options OrderedOptions) (*ChangesFeed, error) {
+	return db.continuousChanges("GET", options, nil)
+}
+
 // ContinuousChangesWithBody opens a regular changes feed, but uses a POST
 // that includes a JSON payload of the provided body.
 func (db *DB) ContinuousChangesWithBody(options Options, body interface{}) (*ChangesFeed, error) {
+	return db.continuousChangesWithBody(options, body)
+}
+
+// ContinuousChangesWithBodyOrdered is like ContinuousChangesWithBody, but
+// encodes the options in the given order.
+func (db *DB) ContinuousChangesWithBodyOrdered(options OrderedOptions, body interface{}) (*ChangesFeed, error) {
+	return db.continuousChangesWithBody(options, body)
+}
+
+func (db *DB) continuousChangesWithBody(options QueryOptions, body interface{}) (*ChangesFeed, error) {
 	json, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
@@ -154,44 +354,142 @@ func (db *DB) ContinuousChangesWithBody(options Options, body interface{}) (*Cha
 	return db.continuousChanges("POST", options, b)
 }
 
-func (db *DB) continuousChanges(method string, options Options, body io.Reader) (*ChangesFeed, error) {
-	options["feed"] = "continuous"
+func (db *DB) continuousChanges(method string, options QueryOptions, body io.Reader) (*ChangesFeed, error) {
+	return db.changes(method, withOptions(options, Option{"feed", "continuous"}), body)
+}
+
+func (db *DB) changes(method string, options QueryOptions, body io.Reader) (*ChangesFeed, error) {
+	mode := "normal"
+	if m, ok := getOption(options, "feed"); ok {
+		if mode, ok = m.(string); !ok {
+			return nil, fmt.Errorf("couchdb: invalid feed option of type %T", m)
+		}
+	}
+	switch mode {
+	case "normal", "longpoll", "continuous", "eventsource":
+	default:
+		return nil, fmt.Errorf("couchdb: unsupported feed mode %q", mode)
+	}
 	path, err := optpath(options, nil, db.name, "_changes")
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := db.request(db.ctx, method, path, body)
+	resp, err := db.request(db.opctx("Changes"), method, path, body)
 	if err != nil {
 		return nil, err
 	}
 
-	feed := &ChangesFeed{
-		DB:      db,
-		conn:    resp.Body,
-		decoder: json.NewDecoder(resp.Body),
+	return newChangesFeed(db, mode, resp.Body), nil
+}
+
+func newChangesFeed(db *DB, mode string, conn io.ReadCloser) *ChangesFeed {
+	feed := &ChangesFeed{DB: db, conn: conn}
+	switch mode {
+	case "continuous":
+		feed.decoder = json.NewDecoder(conn)
+		feed.parser = feed.parseContinuous
+	case "eventsource":
+		feed.reader = bufio.NewReader(conn)
+		feed.parser = feed.parseEventSource
+	default:
+		feed.decoder = json.NewDecoder(conn)
+		feed.parser = feed.parseResults
 	}
+	return feed
+}
 
+// ResilientChanges opens a continuous _changes feed that survives
+// connection failures. CouchDB is asked to send heartbeats, and when the
+// connection drops or no heartbeat arrives in time, the feed reconnects
+// with backoff, resuming with since set to the Seq of the last event.
+// Next only returns an error once reconnecting failed as configured by
+// ropts, which may be nil to use the defaults, or the context of the
+// database is done.
+//
+// The options are used as in ContinuousChanges, except that "heartbeat"
+// is taken from ropts.
+func (db *DB) ResilientChanges(options QueryOptions, ropts *ReconnectOptions) (*ChangesFeed, error) {
+	return db.resilientChanges("GET", options, nil, ropts)
+}
+
+// ResilientChangesWithBody opens a resilient changes feed like
+// ResilientChanges, but uses a POST that includes a JSON payload of the
+// provided body.
+func (db *DB) ResilientChangesWithBody(options QueryOptions, body interface{}, ropts *ReconnectOptions) (*ChangesFeed, error) {
+	json, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return db.resilientChanges("POST", options, json, ropts)
+}
+
+func (db *DB) resilientChanges(method string, options QueryOptions, body []byte, ropts *ReconnectOptions) (*ChangesFeed, error) {
+	rc := newReconnector(db.ctx, ropts)
+	reopen := func(since interface{}) (io.ReadCloser, error) {
+		opts := withOptions(options,
+			Option{"feed", "continuous"},
+			Option{"heartbeat", int64(rc.opts.Heartbeat / time.Millisecond)})
+		if since != nil {
+			opts = withOptions(opts, Option{"since", since})
+		}
+		path, err := optpath(opts, nil, db.name, "_changes")
+		if err != nil {
+			return nil, err
+		}
+		var b io.Reader
+		if body != nil {
+			b = bytes.NewReader(body)
+		}
+		resp, err := db.request(db.opctx("ResilientChanges"), method, path, b)
+		if err != nil {
+			return nil, err
+		}
+		return newStallReader(resp.Body, rc.opts.StallTimeout), nil
+	}
+
+	rc.since, _ = getOption(options, "since")
+	rc.reopen = reopen
+	conn, err := reopen(rc.since)
+	if err != nil {
+		return nil, err
+	}
+	feed := newChangesFeed(db, "continuous", conn)
+	feed.rc = rc
 	return feed, nil
 }
 
 // Next decodes the next event. It returns false when the feeds end has been
 // reached or an error has occurred.
 func (f *ChangesFeed) Next() (bool, error) {
-	// the json doesn't include the 'deleted' attr unless it's deleted,
-	// so we need to set this to false before parsing the next row so that
-	// it's not maintained from the previous row
-	f.Deleted = false
+	// the json doesn't include attributes like 'deleted' or 'doc' unless
+	// they are set, so we need to clear them before parsing the next row
+	// so that they're not maintained from the previous row
+	f.ID, f.Deleted, f.Changes, f.Doc = "", false, nil, nil
+	f.Rev, f.Conflicts, f.Attachments = "", nil, nil
 
 	if f.end {
 		return false, nil
 	}
-	if f.err = f.parse(); f.err != nil || f.end {
+	if f.rc != nil {
+		f.err = f.rc.next(f.parse, f.replaceConn)
+	} else {
+		f.err = f.parse()
+	}
+	if f.err != nil || f.end {
 		f.Close()
+	} else if f.rc != nil {
+		f.rc.success(f.Seq)
 	}
 	return !f.end, f.err
 }
 
+// replaceConn replaces the connection of a resilient feed.
+func (f *ChangesFeed) replaceConn(conn io.ReadCloser) {
+	f.conn.Close()
+	f.conn, f.decoder = conn, json.NewDecoder(conn)
+}
+
 // Err returns the last error that occurred during iteration.
 func (f *ChangesFeed) Err() error {
 	return f.err
@@ -201,10 +499,52 @@ func (f *ChangesFeed) Err() error {
 // If Next returns false, the feed has already been closed.
 func (f *ChangesFeed) Close() error {
 	f.end = true
+	if f.rc != nil {
+		f.rc.close()
+	}
 	return f.conn.Close()
 }
 
 func (f *ChangesFeed) parse() error {
+	if err := f.parser(); err != nil || f.end {
+		return err
+	}
+	if len(f.Doc) == 0 || bytes.Equal(f.Doc, []byte("null")) {
+		return nil
+	}
+	var meta struct {
+		Rev         string                    `json:"_rev"`
+		Conflicts   []string                  `json:"_conflicts"`
+		Attachments map[string]AttachmentInfo `json:"_attachments"`
+	}
+	if err := json.Unmarshal(f.Doc, &meta); err != nil {
+		return err
+	}
+	f.Rev, f.Conflicts, f.Attachments = meta.Rev, meta.Conflicts, meta.Attachments
+	return nil
+}
+
+// Revs returns the leaf revisions listed in the current event.
+func (f *ChangesFeed) Revs() []string {
+	revs := make([]string, len(f.Changes))
+	for i, c := range f.Changes {
+		revs[i] = c.Rev
+	}
+	return revs
+}
+
+// DecodeDoc unmarshals the document of the current event into v.
+// The feed option "include_docs" must be true.
+func (f *ChangesFeed) DecodeDoc(v interface{}) error {
+	if len(f.Doc) == 0 {
+		return errors.New("couchdb: no document in changes event, set include_docs")
+	}
+	return json.Unmarshal(f.Doc, v)
+}
+
+// parseContinuous reads an event of a continuous feed, one JSON object
+// per line.
+func (f *ChangesFeed) parseContinuous() error {
 	if err := f.decoder.Decode(f); err != nil {
 		return err
 	}
@@ -214,6 +554,47 @@ func (f *ChangesFeed) parse() error {
 	return err
 }
 
+// parseResults reads an event of a normal or longpoll feed.
+func (f *ChangesFeed) parseResults() error {
+	end, err := f.results.next(f.decoder, f, func(name string) interface{} {
+		switch name {
+		case "last_seq":
+			return &f.LastSeq
+		case "pending":
+			return &f.Pending
+		}
+		return nil
+	})
+	if end {
+		f.Seq, f.end = f.LastSeq, true
+	}
+	return err
+}
+
+// parseEventSource reads an event of an eventsource feed. Heartbeats and
+// other events without data are skipped, the feed ends with the stream.
+func (f *ChangesFeed) parseEventSource() error {
+	var data []byte
+	for {
+		line, err := f.reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if bytes.HasPrefix(line, []byte("data:")) {
+			data = append(data, bytes.TrimSpace(line[len("data:"):])...)
+		}
+		// "id:" repeats the seq of the data, "event:" only marks heartbeats
+		if (len(line) == 0 || err == io.EOF) && len(data) > 0 {
+			return json.Unmarshal(data, f)
+		}
+		if err == io.EOF {
+			f.end = true
+			return nil
+		}
+	}
+}
+
 func (f *ChangesFeed) isEnd() (bool, error) {
 	if f.LastSeq == nil {
 		return false, nil
@@ -236,3 +617,60 @@ func (f *ChangesFeed) isEnd() (bool, error) {
 	}
 
 }
+
+// resultsParser reads the events of a normal or longpoll feed, which
+// consist of a single JSON object with the events in its "results" array.
+// It is shared by the feed types.
+type resultsParser struct {
+	inResults bool
+}
+
+// next decodes the next event into v, or reports that the end of the
+// feed has been reached. The other fields of the object are decoded into
+// the value returned by field for their name, or skipped if it is nil.
+func (p *resultsParser) next(dec *json.Decoder, v interface{}, field func(name string) interface{}) (end bool, err error) {
+	if !p.inResults {
+		if err := expectDelim(dec, '{'); err != nil {
+			return false, err
+		}
+		if found, err := p.readFields(dec, field); err != nil {
+			return false, err
+		} else if !found {
+			return true, nil
+		}
+		p.inResults = true
+	}
+	if dec.More() {
+		return false, dec.Decode(v)
+	}
+	if err := expectDelim(dec, ']'); err != nil {
+		return false, err
+	}
+	if _, err := p.readFields(dec, field); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// readFields reads the fields of the object until either the results
+// array has been opened, or the end of the object has been reached.
+func (p *resultsParser) readFields(dec *json.Decoder, field func(name string) interface{}) (inResults bool, err error) {
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return false, err
+		}
+		if tok == "results" {
+			return true, expectDelim(dec, '[')
+		}
+		name, _ := tok.(string)
+		dst := field(name)
+		if dst == nil {
+			dst = new(json.RawMessage)
+		}
+		if err := dec.Decode(dst); err != nil {
+			return false, err
+		}
+	}
+	return false, expectDelim(dec, '}')
+}
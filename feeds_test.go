@@ -1,10 +1,13 @@
 package couchdb_test
 
 import (
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	. "net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/cabify/go-couchdb"
 )
@@ -85,8 +88,6 @@ func TestContinouousChangesFeed(t *testing.T) {
 		t.Fatalf("client.Changes error: %v", err)
 	}
 
-	// TODO: check "changes"
-
 	t.Log("-- first event")
 	ok, err := feed.Next()
 	check(t, "feed.Next()", true, ok)
@@ -95,6 +96,7 @@ func TestContinouousChangesFeed(t *testing.T) {
 	check(t, "feed.ID", "doc", feed.ID)
 	check(t, "feed.Seq", "1", feed.Seq)
 	check(t, "feed.Deleted", true, feed.Deleted)
+	check(t, "feed.Changes", []couchdb.ChangeRev{{Rev: "1-619db7ba8551c0de3f3a178775509611"}}, feed.Changes)
 
 	t.Log("-- second event")
 	ok, err = feed.Next()
@@ -154,3 +156,372 @@ func TestContinouousChangesFeedWithBody(t *testing.T) {
 	check(t, "feed.Next()", true, ok)
 	check(t, "feed.Err()", error(nil), err)
 }
+
+func TestResilientChangesFeedReconnects(t *testing.T) {
+	c := newTestClient(t)
+	connections := 0
+	c.Handle("GET /db/_changes", func(resp ResponseWriter, req *Request) {
+		connections++
+		query := req.URL.Query()
+		check(t, "feed", "continuous", query.Get("feed"))
+		check(t, "heartbeat", "50", query.Get("heartbeat"))
+		switch connections {
+		case 1:
+			check(t, "since", "", query.Get("since"))
+			io.WriteString(resp, `{"seq":"1-a","id":"doc1","changes":[{"rev":"1-a"}]}`+"\n")
+			// the connection drops in the middle of an event
+			io.WriteString(resp, `{"seq":"2-b","id":"do`)
+		case 2:
+			// CouchDB is restarting
+			resp.WriteHeader(StatusServiceUnavailable)
+			io.WriteString(resp, `{"error":"unavailable","reason":"try again"}`)
+		case 3:
+			check(t, "since", "1-a", query.Get("since"))
+			io.WriteString(resp, `{"seq":"2-b","id":"doc2","changes":[{"rev":"1-b"}]}`+"\n")
+			io.WriteString(resp, `{"last_seq":"2-b"}`+"\n")
+		}
+	})
+
+	feed, err := c.DB("db").ResilientChanges(couchdb.Options{}, &couchdb.ReconnectOptions{
+		Heartbeat:  50 * time.Millisecond,
+		MinBackoff: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for {
+		ok, err := feed.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		ids = append(ids, feed.ID)
+	}
+	check(t, "ids", []string{"doc1", "doc2"}, ids)
+	check(t, "connections", 3, connections)
+	check(t, "feed.LastSeq", "2-b", feed.LastSeq)
+}
+
+func TestResilientChangesFeedStalled(t *testing.T) {
+	connections := 0
+	rt := roundTripperFunc(func(req *Request) (*Response, error) {
+		connections++
+		var body io.ReadCloser
+		if connections == 1 {
+			// nothing is ever sent on this connection
+			body, _ = io.Pipe()
+		} else {
+			check(t, "since", "now", req.URL.Query().Get("since"))
+			body = ioutil.NopCloser(strings.NewReader(`{"seq":"3-c","id":"doc"}` + "\n" + `{"last_seq":"3-c"}` + "\n"))
+		}
+		return &Response{StatusCode: StatusOK, Body: body, Request: req}, nil
+	})
+	c := couchdb.NewClient(asURL("http://testClient:5984/"), &Client{Transport: rt}, nil)
+
+	feed, err := c.DB("db").ResilientChanges(couchdb.Options{"since": "now"}, &couchdb.ReconnectOptions{
+		StallTimeout: 10 * time.Millisecond,
+		MinBackoff:   time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := feed.Next()
+	check(t, "feed.Next()", true, ok)
+	check(t, "feed.Err()", error(nil), err)
+	check(t, "feed.ID", "doc", feed.ID)
+	check(t, "connections", 2, connections)
+	feed.Close()
+}
+
+func TestResilientChangesFeedSlowConsumer(t *testing.T) {
+	connections := 0
+	rt := roundTripperFunc(func(req *Request) (*Response, error) {
+		connections++
+		if connections > 1 {
+			return &Response{
+				StatusCode: StatusNotFound,
+				Body:       ioutil.NopCloser(strings.NewReader(`{"error":"not_found","reason":"reconnected"}`)),
+				Request:    req,
+			}, nil
+		}
+		body, w := io.Pipe()
+		go func() {
+			io.WriteString(w, `{"seq":"1-a","id":"doc1"}`+"\n")
+			io.WriteString(w, `{"seq":"2-b","id":"doc2"}`+"\n")
+			io.WriteString(w, `{"last_seq":"2-b"}`+"\n")
+			w.Close()
+		}()
+		return &Response{StatusCode: StatusOK, Body: body, Request: req}, nil
+	})
+	c := couchdb.NewClient(asURL("http://testClient:5984/"), &Client{Transport: rt}, nil)
+
+	feed, err := c.DB("db").ResilientChanges(nil, &couchdb.ReconnectOptions{
+		StallTimeout: 10 * time.Millisecond,
+		MinBackoff:   time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for {
+		ok, err := feed.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		ids = append(ids, feed.ID)
+		// processing takes longer than the stall timeout
+		time.Sleep(30 * time.Millisecond)
+	}
+	check(t, "ids", []string{"doc1", "doc2"}, ids)
+	check(t, "connections", 1, connections)
+}
+
+func TestResilientChangesFeedGivesUp(t *testing.T) {
+	c := newTestClient(t)
+	connections := 0
+	c.Handle("GET /db/_changes", func(resp ResponseWriter, req *Request) {
+		connections++
+		if connections > 1 {
+			resp.WriteHeader(StatusNotFound)
+			io.WriteString(resp, `{"error":"not_found","reason":"Database does not exist."}`)
+		}
+	})
+
+	feed, err := c.DB("db").ResilientChanges(nil, &couchdb.ReconnectOptions{MinBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := feed.Next()
+	check(t, "feed.Next()", false, ok)
+	check(t, "couchdb.NotFound(err)", true, couchdb.NotFound(err))
+	check(t, "connections", 2, connections)
+}
+
+func TestNormalChangesFeed(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_changes", func(resp ResponseWriter, req *Request) {
+		check(t, "request query string", "since=5", req.URL.RawQuery)
+		io.WriteString(resp, `{"results":[
+			{"seq":"6-a","id":"doc1","changes":[{"rev":"1-a"}]},
+			{"seq":"7-b","id":"doc2","deleted":true,"changes":[{"rev":"2-b"}]}
+		],
+		"last_seq":"7-b","pending":3}`)
+	})
+
+	opts := couchdb.Options{"since": 5}
+	feed, err := c.DB("db").Changes(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "options", couchdb.Options{"since": 5}, opts)
+
+	t.Log("-- first event")
+	ok, err := feed.Next()
+	check(t, "feed.Next()", true, ok)
+	check(t, "feed.Err()", error(nil), err)
+	check(t, "feed.ID", "doc1", feed.ID)
+	check(t, "feed.Seq", "6-a", feed.Seq)
+
+	t.Log("-- second event")
+	ok, err = feed.Next()
+	check(t, "feed.Next()", true, ok)
+	check(t, "feed.ID", "doc2", feed.ID)
+	check(t, "feed.Deleted", true, feed.Deleted)
+
+	t.Log("-- end of feed")
+	ok, err = feed.Next()
+	check(t, "feed.Next()", false, ok)
+	check(t, "feed.Err()", error(nil), err)
+	check(t, "feed.Seq", "7-b", feed.Seq)
+	check(t, "feed.LastSeq", "7-b", feed.LastSeq)
+	check(t, "feed.Pending", int64(3), feed.Pending)
+}
+
+func TestLongpollChangesFeedEmpty(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_changes", func(resp ResponseWriter, req *Request) {
+		check(t, "request query string", "feed=longpoll", req.URL.RawQuery)
+		io.WriteString(resp, `{"results":[],"last_seq":"9-c","pending":0}`)
+	})
+
+	feed, err := c.DB("db").Changes(couchdb.Options{"feed": "longpoll"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := feed.Next()
+	check(t, "feed.Next()", false, ok)
+	check(t, "feed.Err()", error(nil), err)
+	check(t, "feed.Seq", "9-c", feed.Seq)
+}
+
+func TestEventSourceChangesFeed(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("POST /db/_changes", func(resp ResponseWriter, req *Request) {
+		check(t, "request query string", "feed=eventsource", req.URL.RawQuery)
+		io.WriteString(resp, "data: {\"seq\":\"1-a\",\"id\":\"doc1\",\"changes\":[{\"rev\":\"1-a\"}]}\n"+
+			"id: 1-a\n\n"+
+			"event: heartbeat\ndata: \n\n"+
+			"data: {\"seq\":\"2-b\",\"id\":\"doc2\",\"changes\":[{\"rev\":\"1-b\"}]}\n"+
+			"id: 2-b\n\n")
+	})
+
+	feed, err := c.DB("db").ChangesWithBody(couchdb.Options{"feed": "eventsource"},
+		map[string]interface{}{"doc_ids": []string{"doc1", "doc2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for {
+		ok, err := feed.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		ids = append(ids, feed.ID)
+	}
+	check(t, "ids", []string{"doc1", "doc2"}, ids)
+	check(t, "feed.Seq", "2-b", feed.Seq)
+}
+
+func TestChangesFeedInvalidMode(t *testing.T) {
+	c := newTestClient(t)
+	if _, err := c.DB("db").Changes(couchdb.Options{"feed": "sometimes"}); err == nil {
+		t.Error("expected an error for an unknown feed mode")
+	}
+}
+
+func TestContinuousChangesKeepsOptions(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_changes", func(resp ResponseWriter, req *Request) {
+		check(t, "feed", "continuous", req.URL.Query().Get("feed"))
+	})
+
+	opts := couchdb.Options{"include_docs": true}
+	feed, err := c.DB("db").ContinuousChanges(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed.Close()
+	check(t, "options", couchdb.Options{"include_docs": true}, opts)
+}
+
+func TestChangesFeedMetadata(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_changes", func(resp ResponseWriter, req *Request) {
+		io.WriteString(resp, `{"results":[
+			{"seq":"1-a","id":"doc","changes":[{"rev":"3-a"},{"rev":"3-b"}],"doc":{
+				"_id":"doc","_rev":"3-a","_conflicts":["3-b"],"field":7,
+				"_attachments":{"a.txt":{"content_type":"text/plain","digest":"md5-x","length":5,"revpos":2,"stub":true}}
+			}},
+			{"seq":"2-b","id":"other","changes":[{"rev":"1-c"}]}
+		],"last_seq":"2-b"}`)
+	})
+
+	feed, err := c.DB("db").Changes(couchdb.Options{"style": "all_docs", "include_docs": true, "conflicts": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("-- first event")
+	ok, err := feed.Next()
+	check(t, "feed.Next()", true, ok)
+	check(t, "feed.Err()", error(nil), err)
+	check(t, "feed.Revs()", []string{"3-a", "3-b"}, feed.Revs())
+	check(t, "feed.Rev", "3-a", feed.Rev)
+	check(t, "feed.Conflicts", []string{"3-b"}, feed.Conflicts)
+	check(t, "feed.Attachments", map[string]couchdb.AttachmentInfo{
+		"a.txt": {ContentType: "text/plain", Digest: "md5-x", Length: 5, RevPos: 2, Stub: true},
+	}, feed.Attachments)
+	var doc testDocument
+	check(t, "feed.DecodeDoc()", nil, feed.DecodeDoc(&doc))
+	check(t, "doc", testDocument{ID: "doc", Rev: "3-a", Field: 7}, doc)
+
+	t.Log("-- second event")
+	ok, err = feed.Next()
+	check(t, "feed.Next()", true, ok)
+	check(t, "feed.Revs()", []string{"1-c"}, feed.Revs())
+	check(t, "feed.Doc", json.RawMessage(nil), feed.Doc)
+	check(t, "feed.Rev", "", feed.Rev)
+	check(t, "feed.Conflicts", []string(nil), feed.Conflicts)
+	if err := feed.DecodeDoc(&doc); err == nil {
+		t.Error("expected DecodeDoc to fail without a document")
+	}
+}
+
+func TestDBUpdatesFeedNormal(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /_db_updates", func(resp ResponseWriter, req *Request) {
+		query := req.URL.Query()
+		check(t, "feed", "normal", query.Get("feed"))
+		check(t, "since", "5-e", query.Get("since"))
+		io.WriteString(resp, `{"results":[
+			{"db_name":"tenant1","type":"created","seq":"6-f"},
+			{"db_name":"tenant2","type":"created","seq":"7-g"}
+		],"last_seq":"7-g"}`)
+	})
+
+	feed, err := c.DBUpdates(couchdb.Options{"feed": "normal", "since": "5-e"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dbs []string
+	for feed.Next() {
+		dbs = append(dbs, feed.DB)
+		check(t, "feed.Event", "created", feed.Event)
+	}
+	check(t, "feed.Err()", error(nil), feed.Err())
+	check(t, "dbs", []string{"tenant1", "tenant2"}, dbs)
+	check(t, "feed.Seq", "7-g", feed.Seq)
+}
+
+func TestResilientDBUpdatesFeed(t *testing.T) {
+	c := newTestClient(t)
+	connections := 0
+	c.Handle("GET /_db_updates", func(resp ResponseWriter, req *Request) {
+		connections++
+		query := req.URL.Query()
+		check(t, "feed", "continuous", query.Get("feed"))
+		check(t, "heartbeat", "50", query.Get("heartbeat"))
+		switch connections {
+		case 1:
+			check(t, "since", "1-a", query.Get("since"))
+			io.WriteString(resp, `{"db_name":"tenant1","type":"created","seq":"2-b"}`+"\n\n")
+		case 2:
+			check(t, "since", "2-b", query.Get("since"))
+			io.WriteString(resp, `{"db_name":"tenant2","type":"created","seq":"3-c"}`+"\n")
+			io.WriteString(resp, `{"last_seq":"3-c"}`+"\n")
+		}
+	})
+
+	feed, err := c.ResilientDBUpdates(couchdb.Options{"since": "1-a"}, &couchdb.ReconnectOptions{
+		Heartbeat:  50 * time.Millisecond,
+		MinBackoff: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dbs []string
+	for feed.Next() {
+		dbs = append(dbs, feed.DB)
+	}
+	check(t, "feed.Err()", error(nil), feed.Err())
+	check(t, "dbs", []string{"tenant1", "tenant2"}, dbs)
+	check(t, "connections", 2, connections)
+	check(t, "feed.Seq", "3-c", feed.Seq)
+}
+
+func TestDBUpdatesInvalidFeed(t *testing.T) {
+	c := newTestClient(t)
+	if _, err := c.DBUpdates(couchdb.Options{"feed": "websocket"}); err == nil {
+		t.Error("expected an error for an unsupported feed mode")
+	}
+}
@@ -0,0 +1,181 @@
+package couchdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// FindQuery is a Mango query for DB.Find.
+// Please refer to the CouchDB documentation for the meaning of each field:
+//
+// http://docs.couchdb.org/en/latest/api/database/find.html
+type FindQuery struct {
+	// Selector is any value that marshals into a Mango selector,
+	// e.g. a map[string]interface{}.
+	Selector interface{} `json:"selector"`
+
+	Limit  int           `json:"limit,omitempty"`
+	Skip   int           `json:"skip,omitempty"`
+	Sort   []interface{} `json:"sort,omitempty"`
+	Fields []string      `json:"fields,omitempty"`
+
+	// UseIndex is either a design document name or a
+	// [design document name, index name] pair.
+	UseIndex interface{} `json:"use_index,omitempty"`
+
+	Conflicts      bool   `json:"conflicts,omitempty"`
+	R              int    `json:"r,omitempty"`
+	Bookmark       string `json:"bookmark,omitempty"`
+	Update         *bool  `json:"update,omitempty"`
+	Stable         bool   `json:"stable,omitempty"`
+	ExecutionStats bool   `json:"execution_stats,omitempty"`
+}
+
+// FindResult holds everything returned by DB.Find except the documents.
+type FindResult struct {
+	// Bookmark can be set on the query to fetch the next page of results.
+	Bookmark string `json:"bookmark"`
+	// Warning is set when the query could not use an index.
+	Warning string `json:"warning,omitempty"`
+	// ExecutionStats is only set if requested on the query.
+	ExecutionStats *ExecutionStats `json:"execution_stats,omitempty"`
+}
+
+// ExecutionStats describes how a Mango query was executed.
+type ExecutionStats struct {
+	TotalKeysExamined       int     `json:"total_keys_examined"`
+	TotalDocsExamined       int     `json:"total_docs_examined"`
+	TotalQuorumDocsExamined int     `json:"total_quorum_docs_examined"`
+	ResultsReturned         int     `json:"results_returned"`
+	ExecutionTimeMs         float64 `json:"execution_time_ms"`
+}
+
+type findResp struct {
+	FindResult
+	Docs json.RawMessage `json:"docs"`
+}
+
+// Find runs a Mango query.
+// The matching documents are unmarshalled into docs, which should be
+// a pointer to a slice.
+//
+// http://docs.couchdb.org/en/latest/api/database/find.html#db-find
+func (db *DB) Find(query *FindQuery, docs interface{}) (*FindResult, error) {
+	var resp findResp
+	if err := db.find(query, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Docs) > 0 && docs != nil {
+		if err := json.Unmarshal(resp.Docs, docs); err != nil {
+			return nil, err
+		}
+	}
+	return &resp.FindResult, nil
+}
+
+func (db *DB) find(query *FindQuery, result interface{}) error {
+	if query == nil {
+		return errors.New("couchdb.Find: nil query")
+	}
+	json, err := json.Marshal(query)
+	if err != nil {
+		return err
+	}
+	body := bytes.NewReader(json)
+	resp, err := db.request(db.opctx("Find"), "POST", path(db.name, "_find"), body)
+	if err != nil {
+		return err
+	}
+	return readBody(resp, result)
+}
+
+// FindIterator iterates over all the documents matching a Mango query,
+// following bookmarks to fetch one page of results after the other.
+// On each call to the Next method, Doc is set to the current document.
+//
+//	iter := db.FindAll(&couchdb.FindQuery{Selector: sel, Limit: 100})
+//	for iter.Next() {
+//		var doc MyDoc
+//		err := iter.Decode(&doc)
+//		...
+//	}
+//	err := iter.Err()
+type FindIterator struct {
+	// Doc is the current document.
+	Doc json.RawMessage
+
+	// Result describes the page the current document belongs to.
+	Result FindResult
+
+	db    *DB
+	query FindQuery
+	page  []json.RawMessage
+	end   bool
+	err   error
+}
+
+// FindAll returns an iterator over all documents matching query.
+// The query's Limit sets the size of each page, the iteration stops
+// when a page is not full or CouchDB returns no more documents.
+func (db *DB) FindAll(query *FindQuery) *FindIterator {
+	iter := &FindIterator{db: db}
+	if query == nil {
+		iter.err, iter.end = errors.New("couchdb.FindAll: nil query"), true
+	} else {
+		iter.query = *query
+	}
+	return iter
+}
+
+// Next advances to the next document. It returns false when all
+// documents have been seen or an error occurred.
+func (it *FindIterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.end {
+			it.Doc = nil
+			return false
+		}
+		it.fetch()
+	}
+	it.Doc, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+func (it *FindIterator) fetch() {
+	var resp struct {
+		FindResult
+		Docs []json.RawMessage `json:"docs"`
+	}
+	if it.err = it.db.find(&it.query, &resp); it.err != nil {
+		it.end = true
+		return
+	}
+	it.Result, it.page = resp.FindResult, resp.Docs
+	if len(resp.Docs) == 0 || (it.query.Limit > 0 && len(resp.Docs) < it.query.Limit) ||
+		resp.Bookmark == "" || resp.Bookmark == it.query.Bookmark {
+		it.end = true
+	}
+	it.query.Bookmark = resp.Bookmark
+	// CouchDB skips documents after the bookmark too.
+	it.query.Skip = 0
+}
+
+// Decode unmarshals the current document into v.
+func (it *FindIterator) Decode(v interface{}) error {
+	if it.Doc == nil {
+		return errors.New("couchdb: no current document")
+	}
+	return json.Unmarshal(it.Doc, v)
+}
+
+// Bookmark returns the bookmark of the last page fetched. It can be used
+// to resume the iteration later on.
+func (it *FindIterator) Bookmark() string {
+	return it.query.Bookmark
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *FindIterator) Err() error {
+	return it.err
+}
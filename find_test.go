@@ -0,0 +1,146 @@
+package couchdb_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cabify/go-couchdb"
+)
+
+func TestFind(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("POST /db/_find", func(resp http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		check(t, "request body",
+			`{"selector":{"field":{"$gt":1}},"limit":2,"fields":["_id","field"],"execution_stats":true}`,
+			string(body))
+		io.WriteString(resp, `{
+			"docs": [
+				{"_id": "a", "field": 2},
+				{"_id": "b", "field": 3}
+			],
+			"bookmark": "g1AAAA",
+			"warning": "No matching index found, create an index to optimize query time.",
+			"execution_stats": {
+				"total_keys_examined": 0,
+				"total_docs_examined": 5,
+				"total_quorum_docs_examined": 0,
+				"results_returned": 2,
+				"execution_time_ms": 1.5
+			}
+		}`)
+	})
+
+	var docs []testDocument
+	res, err := c.DB("db").Find(&couchdb.FindQuery{
+		Selector:       map[string]interface{}{"field": map[string]interface{}{"$gt": 1}},
+		Limit:          2,
+		Fields:         []string{"_id", "field"},
+		ExecutionStats: true,
+	}, &docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "docs", []testDocument{{ID: "a", Field: 2}, {ID: "b", Field: 3}}, docs)
+	check(t, "res.Bookmark", "g1AAAA", res.Bookmark)
+	check(t, "res.Warning", "No matching index found, create an index to optimize query time.", res.Warning)
+	check(t, "res.ExecutionStats", &couchdb.ExecutionStats{
+		TotalDocsExamined: 5,
+		ResultsReturned:   2,
+		ExecutionTimeMs:   1.5,
+	}, res.ExecutionStats)
+}
+
+func TestFindAll(t *testing.T) {
+	c := newTestClient(t)
+	pages := map[string]string{
+		"":   `{"docs":[{"_id":"a"},{"_id":"b"}],"bookmark":"b1"}`,
+		"b1": `{"docs":[{"_id":"c"},{"_id":"d"}],"bookmark":"b2"}`,
+		"b2": `{"docs":[],"bookmark":"b2"}`,
+	}
+	c.Handle("POST /db/_find", func(resp http.ResponseWriter, req *http.Request) {
+		var query couchdb.FindQuery
+		if err := json.NewDecoder(req.Body).Decode(&query); err != nil {
+			t.Fatal(err)
+		}
+		check(t, "query.Limit", 2, query.Limit)
+		io.WriteString(resp, pages[query.Bookmark])
+	})
+
+	iter := c.DB("db").FindAll(&couchdb.FindQuery{
+		Selector: map[string]interface{}{},
+		Limit:    2,
+	})
+	var ids []string
+	for iter.Next() {
+		var doc testDocument
+		if err := iter.Decode(&doc); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, doc.ID)
+	}
+	check(t, "iter.Err()", nil, iter.Err())
+	check(t, "ids", []string{"a", "b", "c", "d"}, ids)
+	check(t, "iter.Bookmark()", "b2", iter.Bookmark())
+}
+
+func TestFindAllSkip(t *testing.T) {
+	c := newTestClient(t)
+	ids := []string{"a", "b", "c", "d", "e", "f"}
+	c.Handle("POST /db/_find", func(resp http.ResponseWriter, req *http.Request) {
+		var query couchdb.FindQuery
+		if err := json.NewDecoder(req.Body).Decode(&query); err != nil {
+			t.Fatal(err)
+		}
+		// Like CouchDB, skip documents after the bookmark, which is the
+		// number of documents that have been returned.
+		start, _ := strconv.Atoi(query.Bookmark)
+		start += query.Skip
+		if start > len(ids) {
+			start = len(ids)
+		}
+		end := start + query.Limit
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var docs []string
+		for _, id := range ids[start:end] {
+			docs = append(docs, `{"_id":"`+id+`"}`)
+		}
+		fmt.Fprintf(resp, `{"docs":[%s],"bookmark":"%d"}`, strings.Join(docs, ","), end)
+	})
+
+	iter := c.DB("db").FindAll(&couchdb.FindQuery{
+		Selector: map[string]interface{}{},
+		Limit:    2,
+		Skip:     1,
+	})
+	var got []string
+	for iter.Next() {
+		var doc testDocument
+		if err := iter.Decode(&doc); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, doc.ID)
+	}
+	check(t, "iter.Err()", nil, iter.Err())
+	check(t, "ids", []string{"b", "c", "d", "e", "f"}, got)
+}
+
+func TestFindError(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("POST /db/_find", func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusBadRequest)
+		io.WriteString(resp, `{"error":"invalid_selector","reason":"bad"}`)
+	})
+
+	iter := c.DB("db").FindAll(&couchdb.FindQuery{Selector: map[string]interface{}{}})
+	check(t, "iter.Next()", false, iter.Next())
+	check(t, "couchdb.ErrorStatus(err, 400)", true, couchdb.ErrorStatus(iter.Err(), http.StatusBadRequest))
+}
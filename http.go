@@ -11,14 +11,93 @@ import (
 	"net/http"
 	"net/url"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 )
 
 // Options represents CouchDB query string parameters.
+// They are encoded in the order of their keys, so that a given query
+// always produces the same URL. Use OrderedOptions for another order.
 type Options map[string]interface{}
 
+// OrderedOptions represents CouchDB query string parameters that are
+// encoded in the order of the slice. Methods that take Options have
+// a variant ending in Ordered that takes OrderedOptions instead.
+type OrderedOptions []Option
+
+// Option is a single query string parameter.
+type Option struct {
+	Key   string
+	Value interface{}
+}
+
+// QueryOptions are the query string parameters accepted by Client and DB
+// methods, either Options or OrderedOptions. nil sends no parameters.
+type QueryOptions interface {
+	// list returns the parameters in the order they are encoded.
+	list() []Option
+}
+
+func (opts Options) list() []Option {
+	keys := make([]string, 0, len(opts))
+	for k := range opts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	list := make([]Option, len(keys))
+	for i, k := range keys {
+		list[i] = Option{k, opts[k]}
+	}
+	return list
+}
+
+func (opts OrderedOptions) list() []Option {
+	return opts
+}
+
+// getOption returns the value of the parameter called key.
+func getOption(opts QueryOptions, key string) (interface{}, bool) {
+	if opts == nil {
+		return nil, false
+	}
+	for _, opt := range opts.list() {
+		if opt.Key == key {
+			return opt.Value, true
+		}
+	}
+	return nil, false
+}
+
+// withOptions returns a copy of opts with the given parameters set.
+// For OrderedOptions, existing parameters are replaced in place and new
+// ones are appended.
+func withOptions(opts QueryOptions, set ...Option) QueryOptions {
+	ordered, ok := opts.(OrderedOptions)
+	if !ok {
+		m, _ := opts.(Options)
+		result := m.clone()
+		for _, opt := range set {
+			result[opt.Key] = opt.Value
+		}
+		return result
+	}
+	result := append(OrderedOptions(nil), ordered...)
+	for _, opt := range set {
+		found := false
+		for i := range result {
+			if result[i].Key == opt.Key {
+				result[i].Value, found = opt.Value, true
+			}
+		}
+		if !found {
+			result = append(result, opt)
+		}
+	}
+	return result
+}
+
 // Payload represents CouchDB body parameters.
 type Payload map[string]interface{}
 
@@ -36,6 +115,11 @@ type transport struct {
 	http   *http.Client
 	mu     sync.RWMutex
 	auth   Auth
+	retry  *RetryPolicy
+
+	middleware []Middleware
+	instr      Instrumentation
+	cluster    *cluster // only set for cluster clients
 }
 
 func newTransport(prefix string, httpClient *http.Client, auth Auth) *transport {
@@ -55,6 +139,21 @@ func (t *transport) setAuth(a Auth) {
 	t.mu.Unlock()
 }
 
+func (t *transport) setRetryPolicy(p *RetryPolicy) {
+	if p != nil {
+		p = p.withDefaults()
+	}
+	t.mu.Lock()
+	t.retry = p
+	t.mu.Unlock()
+}
+
+func (t *transport) getRetryPolicy() *RetryPolicy {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.retry
+}
+
 func (t *transport) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
 	req, err := http.NewRequest(method, t.prefix+path, body)
 	if err != nil {
@@ -63,12 +162,29 @@ func (t *transport) newRequest(ctx context.Context, method, path string, body io
 	if ctx != context.Background() { // Save unnecessary copying
 		req = req.WithContext(ctx)
 	}
+	if err := t.authorize(ctx, t.getAuth(), req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (t *transport) getAuth() Auth {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
-	if t.auth != nil {
-		t.auth.AddAuth(req)
+	return t.auth
+}
+
+// authorize adds the credentials of auth to req.
+func (t *transport) authorize(ctx context.Context, auth Auth, req *http.Request) error {
+	switch a := auth.(type) {
+	case nil:
+		return nil
+	case requestAuth:
+		return a.authRequest(ctx, t, req)
+	default:
+		a.AddAuth(req)
+		return nil
 	}
-	return req, nil
 }
 
 // request sends an HTTP request to a CouchDB server.
@@ -77,6 +193,7 @@ func (t *transport) newRequest(ctx context.Context, method, path string, body io
 // encoded query string.
 //
 // Status codes >= 400 are treated as errors.
+// Failed requests are retried as configured by the retry policy.
 func (t *transport) request(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
 	req, err := t.newRequest(ctx, method, path, body)
 	if err != nil {
@@ -85,14 +202,68 @@ func (t *transport) request(ctx context.Context, method, path string, body io.Re
 	if method != "GET" {
 		req.Header.Set("Content-Type", "application/json")
 	}
-	resp, err := t.http.Do(req)
-	if err != nil {
+	return t.send(req)
+}
+
+// sendRenewing sends req with rt. If the request was authenticated by a
+// session based Auth and the server rejects it with 401 Unauthorized, the
+// session is renewed and the request is sent with rt once more, provided
+// its body can be replayed.
+func (t *transport) sendRenewing(rt RoundTripFunc, req *http.Request) (*http.Response, error) {
+	resp, err := rt(req)
+	auth := t.getAuth()
+	sa, ok := auth.(sessionAuth)
+	if !ok || !Unauthorized(err) || !sa.renew(req) {
+		return resp, err
+	}
+	retry, rerr := replayRequest(req)
+	if rerr != nil || retry == nil {
+		return resp, err
+	}
+	retry.Header.Del("Cookie")
+	if err := t.authorize(retry.Context(), auth, retry); err != nil {
 		return nil, err
-	} else if resp.StatusCode >= 400 {
-		return nil, parseError(resp) // the Body is closed by parseError
-	} else {
-		return resp, nil
 	}
+	return rt(retry)
+}
+
+// observe passes resp to a session based Auth, which may pick up renewed
+// credentials.
+func (t *transport) observe(resp *http.Response) {
+	if sa, ok := t.getAuth().(sessionAuth); ok {
+		sa.observe(resp)
+	}
+}
+
+// roundTrip sends req with the HTTP client, to a node of the cluster
+// if there is one.
+func (t *transport) roundTrip(req *http.Request) (*http.Response, error) {
+	if t.cluster != nil {
+		return t.cluster.roundTrip(t, req)
+	}
+	return t.http.Do(req)
+}
+
+// replayRequest returns a copy of req that can be sent again, or nil if
+// the body of req cannot be read a second time.
+func replayRequest(req *http.Request) (*http.Request, error) {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return nil, nil
+	}
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	return r, nil
 }
 
 // closedRequest sends an HTTP request and discards the response body.
@@ -122,24 +293,27 @@ func revpath(rev string, segs ...string) string {
 	return r
 }
 
-func optpath(opts Options, jskeys []string, segs ...string) (string, error) {
+func optpath(opts QueryOptions, jskeys []string, segs ...string) (string, error) {
 	r := path(segs...)
-	if len(opts) > 0 {
-		os, err := encopts(opts, jskeys)
-		if err != nil {
-			return "", err
-		}
-		r += os
+	os, err := encopts(opts, jskeys)
+	if err != nil {
+		return "", err
 	}
-	return r, nil
+	return r + os, nil
 }
 
-func encopts(opts Options, jskeys []string) (string, error) {
+// encopts encodes options as query string, including the leading '?'.
+// It returns an empty string if there are no options.
+func encopts(opts QueryOptions, jskeys []string) (string, error) {
+	if opts == nil {
+		return "", nil
+	}
 	buf := new(bytes.Buffer)
-	buf.WriteRune('?')
-	amp := false
-	for k, v := range opts {
-		if amp {
+	for _, opt := range opts.list() {
+		k, v := opt.Key, opt.Value
+		if buf.Len() == 0 {
+			buf.WriteByte('?')
+		} else {
 			buf.WriteByte('&')
 		}
 		buf.WriteString(url.QueryEscape(k))
@@ -162,7 +336,6 @@ func encopts(opts Options, jskeys []string) (string, error) {
 				return "", fmt.Errorf("invalid option %q: %v", k, err)
 			}
 		}
-		amp = true
 	}
 	return buf.String(), nil
 }
@@ -241,6 +414,8 @@ type Error struct {
 	// These two fields will be empty for HEAD requests.
 	ErrorCode string // Error reason provided by CouchDB
 	Reason    string // Error message provided by CouchDB
+
+	header http.Header // of the response, e.g. for Retry-After
 }
 
 func (e *Error) Error() string {
@@ -292,5 +467,6 @@ func parseError(resp *http.Response) error {
 		StatusCode: resp.StatusCode,
 		ErrorCode:  reply.Error,
 		Reason:     reply.Reason,
+		header:     resp.Header,
 	}
 }
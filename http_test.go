@@ -3,6 +3,8 @@ package couchdb_test
 import (
 	. "net/http"
 	"testing"
+
+	"github.com/cabify/go-couchdb"
 )
 
 type testauth struct{ called bool }
@@ -33,3 +35,84 @@ func TestClientSetAuth(t *testing.T) {
 		t.Error("AddAuth was called after removing Auth instance")
 	}
 }
+
+func TestOptionsEncodingIsDeterministic(t *testing.T) {
+	c := newTestClient(t)
+	var queries []string
+	c.Handle("GET /db/_design/test/_view/view", func(resp ResponseWriter, req *Request) {
+		queries = append(queries, req.URL.RawQuery)
+		resp.Write([]byte(`{"rows":[]}`))
+	})
+
+	opts := couchdb.Options{
+		"startkey":     []string{"a"},
+		"endkey":       []string{"b"},
+		"limit":        10,
+		"include_docs": true,
+		"descending":   false,
+		"stale":        "ok",
+	}
+	for i := 0; i < 20; i++ {
+		if err := c.DB("db").View("test", "view", nil, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "descending=false&endkey=%5B%22b%22%5D&include_docs=true&limit=10&stale=ok&startkey=%5B%22a%22%5D"
+	for i, query := range queries {
+		if query != want {
+			t.Fatalf("query %d mismatch:\nwant %s\ngot  %s", i, want, query)
+		}
+	}
+}
+
+func TestOrderedOptions(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_all_docs", func(resp ResponseWriter, req *Request) {
+		check(t, "query", "limit=5&startkey=%22a%22&endkey=%22z%22&skip=1", req.URL.RawQuery)
+		resp.Write([]byte(`{"rows":[]}`))
+	})
+
+	opts := couchdb.OrderedOptions{
+		{Key: "limit", Value: 5},
+		{Key: "startkey", Value: "a"},
+		{Key: "endkey", Value: "z"},
+		{Key: "skip", Value: 1},
+	}
+	if err := c.DB("db").AllDocsOrdered(nil, opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOptionsFromMap(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_all_docs", func(resp ResponseWriter, req *Request) {
+		check(t, "query", "limit=5&skip=1", req.URL.RawQuery)
+		resp.Write([]byte(`{"rows":[]}`))
+	})
+
+	// plain maps are still accepted as Options
+	opts := map[string]interface{}{"skip": 1, "limit": 5}
+	if err := c.DB("db").AllDocs(nil, opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOrderedOptionsOfFeeds(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_changes", func(resp ResponseWriter, req *Request) {
+		check(t, "query", "since=now&feed=continuous&include_docs=true", req.URL.RawQuery)
+		resp.Write([]byte(`{"last_seq":"1-a"}` + "\n"))
+	})
+
+	opts := couchdb.OrderedOptions{
+		{Key: "since", Value: "now"},
+		{Key: "feed", Value: "normal"},
+		{Key: "include_docs", Value: true},
+	}
+	feed, err := c.DB("db").ContinuousChangesOrdered(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed.Close()
+	check(t, "feed option after the request", "normal", opts[1].Value)
+}
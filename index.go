@@ -0,0 +1,139 @@
+package couchdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// IndexDef is the definition of a Mango index.
+// Fields holds field names, or {"field": "asc"|"desc"} objects for json
+// indexes and {"name": ..., "type": ...} objects for text indexes.
+type IndexDef struct {
+	Fields                []interface{} `json:"fields"`
+	PartialFilterSelector interface{}   `json:"partial_filter_selector,omitempty"`
+
+	// These are only used by text indexes.
+	DefaultField      interface{} `json:"default_field,omitempty"`
+	Selector          interface{} `json:"selector,omitempty"`
+	Analyzer          interface{} `json:"analyzer,omitempty"`
+	IndexArrayLengths *bool       `json:"index_array_lengths,omitempty"`
+}
+
+// IndexRequest describes a Mango index to be created by DB.CreateIndex.
+// DDoc and Name are chosen by CouchDB if left empty, Type defaults
+// to "json".
+type IndexRequest struct {
+	Index       IndexDef `json:"index"`
+	DDoc        string   `json:"ddoc,omitempty"`
+	Name        string   `json:"name,omitempty"`
+	Type        string   `json:"type,omitempty"`
+	Partitioned *bool    `json:"partitioned,omitempty"`
+}
+
+// IndexResult is the outcome of DB.CreateIndex.
+type IndexResult struct {
+	Result string `json:"result"` // "created" | "exists"
+	ID     string `json:"id"`     // ID of the design document
+	Name   string `json:"name"`   // Name of the index
+}
+
+// Index is a Mango index as listed by DB.Indexes.
+type Index struct {
+	DDoc        string   `json:"ddoc"` // null for the special _all_docs index
+	Name        string   `json:"name"`
+	Type        string   `json:"type"` // "json" | "text" | "special"
+	Partitioned bool     `json:"partitioned"`
+	Def         IndexDef `json:"def"`
+}
+
+// CreateIndex creates a Mango index. Creating an index that
+// already exists is not an error, the result will say "exists".
+//
+// http://docs.couchdb.org/en/latest/api/database/find.html#db-index
+func (db *DB) CreateIndex(index *IndexRequest) (*IndexResult, error) {
+	if index == nil {
+		return nil, errors.New("couchdb.CreateIndex: nil index")
+	}
+	json, err := json.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+	body := bytes.NewReader(json)
+	resp, err := db.request(db.opctx("CreateIndex"), "POST", path(db.name, "_index"), body)
+	if err != nil {
+		return nil, err
+	}
+	result := new(IndexResult)
+	if err := readBody(resp, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Indexes lists all the Mango indexes of the database,
+// including the special _all_docs index.
+func (db *DB) Indexes() ([]Index, error) {
+	resp, err := db.request(db.opctx("Indexes"), "GET", path(db.name, "_index"), nil)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Indexes []Index `json:"indexes"`
+	}
+	if err := readBody(resp, &result); err != nil {
+		return nil, err
+	}
+	return result.Indexes, nil
+}
+
+// DeleteIndex removes a Mango index.
+// The ddoc parameter may include or omit the _design/ prefix,
+// indexType defaults to "json" when empty.
+func (db *DB) DeleteIndex(ddoc, indexType, name string) error {
+	ddoc = strings.TrimPrefix(ddoc, "_design/")
+	if indexType == "" {
+		indexType = "json"
+	}
+	p := path(db.name, "_index", ddoc, indexType, name)
+	_, err := db.closedRequest(db.viewctx("DeleteIndex", ddoc, name), http.MethodDelete, p, nil)
+	return err
+}
+
+// ExplainResult describes how CouchDB would run a Mango query.
+type ExplainResult struct {
+	DBName   string                 `json:"dbname"`
+	Index    Index                  `json:"index"`
+	Selector json.RawMessage        `json:"selector"`
+	Opts     map[string]interface{} `json:"opts"`
+	Limit    int                    `json:"limit"`
+	Skip     int                    `json:"skip"`
+	Fields   interface{}            `json:"fields"` // "all_fields" or a list of fields
+	Range    json.RawMessage        `json:"range,omitempty"`
+}
+
+// Explain reports which index CouchDB would use for a Mango query,
+// without running it.
+//
+// http://docs.couchdb.org/en/latest/api/database/find.html#db-explain
+func (db *DB) Explain(query *FindQuery) (*ExplainResult, error) {
+	if query == nil {
+		return nil, errors.New("couchdb.Explain: nil query")
+	}
+	json, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+	body := bytes.NewReader(json)
+	resp, err := db.request(db.opctx("Explain"), "POST", path(db.name, "_explain"), body)
+	if err != nil {
+		return nil, err
+	}
+	result := new(ExplainResult)
+	if err := readBody(resp, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
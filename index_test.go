@@ -0,0 +1,111 @@
+package couchdb_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/cabify/go-couchdb"
+)
+
+func TestCreateIndex(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("POST /db/_index", func(resp http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		check(t, "request body",
+			`{"index":{"fields":["type",{"created_at":"desc"}]},"ddoc":"by-type","name":"type-created","type":"json","partitioned":false}`,
+			string(body))
+		io.WriteString(resp, `{"result":"created","id":"_design/by-type","name":"type-created"}`)
+	})
+
+	partitioned := false
+	res, err := c.DB("db").CreateIndex(&couchdb.IndexRequest{
+		Index: couchdb.IndexDef{
+			Fields: []interface{}{"type", map[string]string{"created_at": "desc"}},
+		},
+		DDoc:        "by-type",
+		Name:        "type-created",
+		Type:        "json",
+		Partitioned: &partitioned,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "result", &couchdb.IndexResult{Result: "created", ID: "_design/by-type", Name: "type-created"}, res)
+}
+
+func TestIndexes(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_index", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, `{
+			"total_rows": 2,
+			"indexes": [
+				{"ddoc": null, "name": "_all_docs", "type": "special", "def": {"fields": [{"_id": "asc"}]}},
+				{"ddoc": "_design/by-type", "name": "type", "type": "json", "partitioned": false, "def": {"fields": [{"type": "asc"}]}}
+			]
+		}`)
+	})
+
+	indexes, err := c.DB("db").Indexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "len(indexes)", 2, len(indexes))
+	check(t, "indexes[0].Type", "special", indexes[0].Type)
+	check(t, "indexes[1]", couchdb.Index{
+		DDoc: "_design/by-type",
+		Name: "type",
+		Type: "json",
+		Def: couchdb.IndexDef{
+			Fields: []interface{}{map[string]interface{}{"type": "asc"}},
+		},
+	}, indexes[1])
+}
+
+func TestDeleteIndex(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("DELETE /db/_index/by-type/json/type", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, `{"ok":true}`)
+	})
+
+	if err := c.DB("db").DeleteIndex("_design/by-type", "", "type"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only a leading _design/ is removed.
+	c.Handle("DELETE /db/_index/old_design/x/json/type", func(resp http.ResponseWriter, req *http.Request) {
+		check(t, "escaped path", "/db/_index/old_design%2Fx/json/type", req.URL.EscapedPath())
+		io.WriteString(resp, `{"ok":true}`)
+	})
+	if err := c.DB("db").DeleteIndex("old_design/x", "", "type"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("POST /db/_explain", func(resp http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		check(t, "request body", `{"selector":{"type":"user"}}`, string(body))
+		io.WriteString(resp, `{
+			"dbname": "db",
+			"index": {"ddoc": "_design/by-type", "name": "type", "type": "json", "partitioned": false, "def": {"fields": [{"type": "asc"}]}},
+			"selector": {"type": {"$eq": "user"}},
+			"opts": {"use_index": [], "bookmark": "nil", "limit": 25, "skip": 0},
+			"limit": 25,
+			"skip": 0,
+			"fields": "all_fields",
+			"range": {"start_key": ["user"], "end_key": ["user", "<MAX>"]}
+		}`)
+	})
+
+	res, err := c.DB("db").Explain(&couchdb.FindQuery{Selector: map[string]string{"type": "user"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "res.Index.DDoc", "_design/by-type", res.Index.DDoc)
+	check(t, "res.Index.Name", "type", res.Index.Name)
+	check(t, "res.Limit", 25, res.Limit)
+	check(t, "res.Fields", "all_fields", res.Fields)
+}
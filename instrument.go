@@ -0,0 +1,219 @@
+package couchdb
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Operation identifies the method of the package that sent a request.
+type Operation struct {
+	Name string // Name of the method, e.g. "Get", "View" or "BulkDocs"
+	DB   string // Database name, if any
+	DDoc string // Design document of views and indexes, without _design/
+	View string // View or index name
+}
+
+type operationKey struct{}
+
+// OperationFromContext returns the operation of a request. It is set in
+// the context of all requests, as seen by middleware and instrumentation.
+func OperationFromContext(ctx context.Context) (Operation, bool) {
+	op, ok := ctx.Value(operationKey{}).(Operation)
+	return op, ok
+}
+
+// OperationResult describes the outcome of a request.
+type OperationResult struct {
+	StatusCode int           // HTTP status code, zero if no response was received
+	ErrorCode  string        // Error reported by CouchDB, if any
+	Err        error         // The error returned to the caller, if any
+	Duration   time.Duration // Until the response headers, retries included
+}
+
+// Instrumentation observes the requests of a Client, e.g. to record
+// traces or metrics. All requests are reported, retries are part of the
+// request. Requests that stream their response, like feeds, are reported
+// once the response headers have been received.
+type Instrumentation interface {
+	// StartRequest is called before a request is sent. The returned
+	// context is used for the request, finish is called with its result.
+	StartRequest(ctx context.Context, op Operation) (_ context.Context, finish func(OperationResult))
+}
+
+// SetInstrumentation sets the instrumentation of the client.
+// Several instrumentations can be combined with MultiInstrumentation.
+// Use SetInstrumentation(nil) to remove it.
+func (c *Client) SetInstrumentation(i Instrumentation) {
+	c.transport.mu.Lock()
+	c.transport.instr = i
+	c.transport.mu.Unlock()
+}
+
+func (t *transport) getInstrumentation() Instrumentation {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.instr
+}
+
+// withOperation returns a copy of ctx that carries op.
+func withOperation(ctx context.Context, op Operation) context.Context {
+	return context.WithValue(ctx, operationKey{}, op)
+}
+
+// opctx returns the context for a request of the named operation.
+func (c *Client) opctx(name, db string) context.Context {
+	return withOperation(c.ctx, Operation{Name: name, DB: db})
+}
+
+// opctx returns the context for a request of the named operation.
+func (db *DB) opctx(name string) context.Context {
+	return withOperation(db.ctx, Operation{Name: name, DB: db.name})
+}
+
+// viewctx returns the context for a request of the named operation
+// on a view or index.
+func (db *DB) viewctx(name, ddoc, view string) context.Context {
+	return withOperation(db.ctx, Operation{Name: name, DB: db.name, DDoc: ddoc, View: view})
+}
+
+// instrument sends req with send, reporting it to the instrumentation.
+func (t *transport) instrument(req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	instr := t.getInstrumentation()
+	if instr == nil {
+		return send(req)
+	}
+	op, ok := OperationFromContext(req.Context())
+	if !ok {
+		op.Name = req.Method
+	}
+	ctx, finish := instr.StartRequest(req.Context(), op)
+	if ctx != req.Context() {
+		req = req.WithContext(ctx)
+	}
+	start := time.Now()
+	resp, err := send(req)
+	result := OperationResult{Err: err, Duration: time.Since(start)}
+	if resp != nil {
+		result.StatusCode = resp.StatusCode
+	} else if dberr, ok := err.(*Error); ok {
+		result.StatusCode, result.ErrorCode = dberr.StatusCode, dberr.ErrorCode
+	}
+	finish(result)
+	return resp, err
+}
+
+type multiInstrumentation []Instrumentation
+
+// MultiInstrumentation reports requests to all given instrumentations.
+func MultiInstrumentation(is ...Instrumentation) Instrumentation {
+	return multiInstrumentation(is)
+}
+
+func (m multiInstrumentation) StartRequest(ctx context.Context, op Operation) (context.Context, func(OperationResult)) {
+	finishers := make([]func(OperationResult), len(m))
+	for i, instr := range m {
+		ctx, finishers[i] = instr.StartRequest(ctx, op)
+	}
+	return ctx, func(res OperationResult) {
+		for i := len(finishers) - 1; i >= 0; i-- {
+			finishers[i](res)
+		}
+	}
+}
+
+// Tracer starts spans, like the tracers of OpenTelemetry. A small wrapper
+// adapts those to this interface.
+type Tracer interface {
+	Start(ctx context.Context, spanName string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// TracingInstrumentation creates a span for every request, named
+// "couchdb.<Operation>". The span has the attributes "db.system",
+// "db.name", "couchdb.ddoc", "couchdb.view", "http.status_code" and
+// "couchdb.error" where applicable.
+func TracingInstrumentation(tracer Tracer) Instrumentation {
+	return tracing{tracer}
+}
+
+type tracing struct{ tracer Tracer }
+
+func (tr tracing) StartRequest(ctx context.Context, op Operation) (context.Context, func(OperationResult)) {
+	ctx, span := tr.tracer.Start(ctx, "couchdb."+op.Name)
+	span.SetAttribute("db.system", "couchdb")
+	if op.DB != "" {
+		span.SetAttribute("db.name", op.DB)
+	}
+	if op.DDoc != "" {
+		span.SetAttribute("couchdb.ddoc", op.DDoc)
+	}
+	if op.View != "" {
+		span.SetAttribute("couchdb.view", op.View)
+	}
+	return ctx, func(res OperationResult) {
+		if res.StatusCode != 0 {
+			span.SetAttribute("http.status_code", res.StatusCode)
+		}
+		if res.ErrorCode != "" {
+			span.SetAttribute("couchdb.error", res.ErrorCode)
+		}
+		if res.Err != nil {
+			span.RecordError(res.Err)
+		}
+		span.End()
+	}
+}
+
+// Counter is a metric that is incremented by one for every request, e.g.
+// a wrapper of a Prometheus CounterVec.
+type Counter interface {
+	Inc(labels map[string]string)
+}
+
+// Histogram is a metric that observes the duration of every request in
+// seconds, e.g. a wrapper of a Prometheus HistogramVec.
+type Histogram interface {
+	Observe(seconds float64, labels map[string]string)
+}
+
+// MetricsInstrumentation counts requests and observes their duration with
+// the labels "op", "db", "ddoc", "view", "status" and "error". The status
+// label is empty if no response was received. Either metric may be nil.
+func MetricsInstrumentation(requests Counter, duration Histogram) Instrumentation {
+	return metrics{requests, duration}
+}
+
+type metrics struct {
+	requests Counter
+	duration Histogram
+}
+
+func (m metrics) StartRequest(ctx context.Context, op Operation) (context.Context, func(OperationResult)) {
+	return ctx, func(res OperationResult) {
+		labels := map[string]string{
+			"op":     op.Name,
+			"db":     op.DB,
+			"ddoc":   op.DDoc,
+			"view":   op.View,
+			"status": "",
+			"error":  res.ErrorCode,
+		}
+		if res.StatusCode != 0 {
+			labels["status"] = strconv.Itoa(res.StatusCode)
+		}
+		if m.requests != nil {
+			m.requests.Inc(labels)
+		}
+		if m.duration != nil {
+			m.duration.Observe(res.Duration.Seconds(), labels)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package couchdb_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/cabify/go-couchdb"
+)
+
+type testSpan struct {
+	name  string
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) { s.attrs[key] = value }
+func (s *testSpan) RecordError(err error)                      { s.err = err }
+func (s *testSpan) End()                                       { s.ended = true }
+
+type spanKey struct{}
+
+type testTracer struct{ spans []*testSpan }
+
+func (tr *testTracer) Start(ctx context.Context, name string) (context.Context, couchdb.Span) {
+	span := &testSpan{name: name, attrs: map[string]interface{}{}}
+	tr.spans = append(tr.spans, span)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func TestTracingInstrumentation(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_design/test/_view/byname", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, `{"rows":[]}`)
+	})
+	c.Handle("GET /db/missing", func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusNotFound)
+		io.WriteString(resp, `{"error":"not_found","reason":"missing"}`)
+	})
+	tracer := new(testTracer)
+	c.SetInstrumentation(couchdb.TracingInstrumentation(tracer))
+	c.Use(func(next couchdb.RoundTripFunc) couchdb.RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			if req.Context().Value(spanKey{}) == nil {
+				t.Error("span is missing in request context")
+			}
+			return next(req)
+		}
+	})
+
+	db := c.DB("db")
+	if err := db.View("_design/test", "byname", new(couchdb.ViewResult), nil); err != nil {
+		t.Fatal(err)
+	}
+	err := db.Get("missing", nil, nil)
+
+	check(t, "number of spans", 2, len(tracer.spans))
+	check(t, "view span", &testSpan{
+		name: "couchdb.View",
+		attrs: map[string]interface{}{
+			"db.system":        "couchdb",
+			"db.name":          "db",
+			"couchdb.ddoc":     "test",
+			"couchdb.view":     "byname",
+			"http.status_code": 200,
+		},
+		ended: true,
+	}, tracer.spans[0])
+	check(t, "get span", &testSpan{
+		name: "couchdb.Get",
+		attrs: map[string]interface{}{
+			"db.system":        "couchdb",
+			"db.name":          "db",
+			"http.status_code": 404,
+			"couchdb.error":    "not_found",
+		},
+		err:   err,
+		ended: true,
+	}, tracer.spans[1])
+}
+
+type testCounter []map[string]string
+
+func (c *testCounter) Inc(labels map[string]string) { *c = append(*c, labels) }
+
+type testHistogram []float64
+
+func (h *testHistogram) Observe(seconds float64, labels map[string]string) {
+	*h = append(*h, seconds)
+}
+
+func TestMetricsInstrumentation(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("POST /db/_bulk_docs", func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusCreated)
+		io.WriteString(resp, `[{"id":"a","rev":"1-a"}]`)
+	})
+	c.Handle("PUT /db2", func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusPreconditionFailed)
+		io.WriteString(resp, `{"error":"file_exists","reason":"exists"}`)
+	})
+	var (
+		counter   testCounter
+		histogram testHistogram
+	)
+	c.SetInstrumentation(couchdb.MultiInstrumentation(
+		couchdb.MetricsInstrumentation(&counter, &histogram),
+	))
+
+	if _, err := c.DB("db").BulkDocs(testDocument{ID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	c.CreateDB("db2")
+
+	check(t, "counter", testCounter{
+		{"op": "BulkDocs", "db": "db", "ddoc": "", "view": "", "status": "201", "error": ""},
+		{"op": "CreateDB", "db": "db2", "ddoc": "", "view": "", "status": "412", "error": "file_exists"},
+	}, counter)
+	check(t, "observed durations", 2, len(histogram))
+}
@@ -0,0 +1,218 @@
+package couchdb
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRolesClaim is the claim CouchDB reads the roles of a JWT from,
+// unless configured otherwise with jwt_auth/roles_claim_name.
+const DefaultRolesClaim = "_couchdb.roles"
+
+// Token is a JSON Web Token provided by a TokenSource.
+type Token struct {
+	Raw    string    // The encoded token
+	Expiry time.Time // Zero if the token does not expire
+}
+
+// TokenSource provides the tokens used by JWTAuth.
+// Token is called whenever the previous token is about to expire,
+// it is never called concurrently by the same Auth.
+type TokenSource interface {
+	Token() (*Token, error)
+}
+
+type jwtauth struct {
+	src TokenSource
+
+	mu        sync.Mutex
+	tok       *Token
+	refreshAt time.Time // zero if the token does not expire
+}
+
+// JWTAuth returns an Auth that performs CouchDB JWT authentication by
+// sending the tokens of src as Authorization: Bearer header.
+// Tokens are cached until a fifth of their lifetime remains.
+// If src fails to provide a token, the error is returned by the request.
+//
+// http://docs.couchdb.org/en/latest/api/server/authn.html#jwt-authentication
+func JWTAuth(src TokenSource) Auth {
+	return &jwtauth{src: src}
+}
+
+// AddAuth adds the current token. As AddAuth cannot report errors, the
+// header is left out if no token can be obtained. Requests sent by a
+// Client never take this path.
+func (a *jwtauth) AddAuth(req *http.Request) {
+	if tok, err := a.token(); err == nil {
+		req.Header.Set("Authorization", "Bearer "+tok)
+	}
+}
+
+func (a *jwtauth) authRequest(ctx context.Context, t *transport, req *http.Request) error {
+	tok, err := a.token()
+	if err != nil {
+		return fmt.Errorf("couchdb: can't obtain JWT: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+tok)
+	return nil
+}
+
+func (a *jwtauth) token() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if a.tok != nil && (a.refreshAt.IsZero() || now.Before(a.refreshAt)) {
+		return a.tok.Raw, nil
+	}
+	tok, err := a.src.Token()
+	if err != nil {
+		return "", err
+	} else if tok == nil {
+		return "", errors.New("token source returned no token")
+	}
+	a.tok, a.refreshAt = tok, time.Time{}
+	if !tok.Expiry.IsZero() {
+		a.refreshAt = now.Add(tok.Expiry.Sub(now) * 4 / 5)
+	}
+	return tok.Raw, nil
+}
+
+type statictoken Token
+
+// StaticToken returns a TokenSource that always provides the given
+// encoded token. The expiry is read from its "exp" claim, if present.
+func StaticToken(raw string) TokenSource {
+	tok := &statictoken{Raw: raw}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if parts := strings.Split(raw, "."); len(parts) == 3 {
+		if payload, err := base64.RawURLEncoding.DecodeString(parts[1]); err == nil {
+			if json.Unmarshal(payload, &claims) == nil && claims.Exp > 0 {
+				tok.Expiry = time.Unix(claims.Exp, 0)
+			}
+		}
+	}
+	return tok
+}
+
+func (t *statictoken) Token() (*Token, error) {
+	return (*Token)(t), nil
+}
+
+// JWTClaims describes the tokens signed by HMACTokenSource and
+// RSATokenSource.
+type JWTClaims struct {
+	Subject  string // The CouchDB user name ("sub")
+	Issuer   string // Optional "iss" claim
+	Audience string // Optional "aud" claim
+	KeyID    string // Optional "kid" header, selects the key on the server
+
+	// Roles are the CouchDB roles of the user, sent in RolesClaim.
+	Roles []string
+	// RolesClaim defaults to DefaultRolesClaim.
+	RolesClaim string
+
+	// TTL is the lifetime of each token, it defaults to five minutes.
+	TTL time.Duration
+
+	// Extra holds any additional claims.
+	Extra map[string]interface{}
+}
+
+const defaultJWTTTL = 5 * time.Minute
+
+type signer struct {
+	alg    string
+	claims JWTClaims
+	sign   func(signingInput []byte) ([]byte, error)
+}
+
+// HMACTokenSource returns a TokenSource that signs tokens with the
+// given claims using HMAC SHA-256 (HS256).
+func HMACTokenSource(key []byte, claims JWTClaims) TokenSource {
+	return &signer{alg: "HS256", claims: claims, sign: func(in []byte) ([]byte, error) {
+		if len(key) == 0 {
+			return nil, errors.New("empty HMAC key")
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write(in)
+		return mac.Sum(nil), nil
+	}}
+}
+
+// RSATokenSource returns a TokenSource that signs tokens with the
+// given claims using RSASSA-PKCS1-v1_5 SHA-256 (RS256).
+func RSATokenSource(key *rsa.PrivateKey, claims JWTClaims) TokenSource {
+	return &signer{alg: "RS256", claims: claims, sign: func(in []byte) ([]byte, error) {
+		if key == nil {
+			return nil, errors.New("nil RSA key")
+		}
+		sum := sha256.Sum256(in)
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	}}
+}
+
+func (s *signer) Token() (*Token, error) {
+	c := s.claims
+	ttl := c.TTL
+	if ttl <= 0 {
+		ttl = defaultJWTTTL
+	}
+	now := time.Now()
+	exp := now.Add(ttl)
+
+	header := map[string]interface{}{"alg": s.alg, "typ": "JWT"}
+	if c.KeyID != "" {
+		header["kid"] = c.KeyID
+	}
+	payload := make(map[string]interface{}, len(c.Extra)+6)
+	for k, v := range c.Extra {
+		payload[k] = v
+	}
+	payload["sub"] = c.Subject
+	payload["iat"] = now.Unix()
+	payload["exp"] = exp.Unix()
+	if c.Issuer != "" {
+		payload["iss"] = c.Issuer
+	}
+	if c.Audience != "" {
+		payload["aud"] = c.Audience
+	}
+	if c.Roles != nil {
+		rolesClaim := c.RolesClaim
+		if rolesClaim == "" {
+			rolesClaim = DefaultRolesClaim
+		}
+		payload[rolesClaim] = c.Roles
+	}
+
+	var parts [2]string
+	for i, v := range []interface{}{header, payload} {
+		js, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = base64.RawURLEncoding.EncodeToString(js)
+	}
+	signingInput := parts[0] + "." + parts[1]
+	sig, err := s.sign([]byte(signingInput))
+	if err != nil {
+		return nil, fmt.Errorf("%s signing failed: %v", s.alg, err)
+	}
+	raw := signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+	return &Token{Raw: raw, Expiry: exp}, nil
+}
@@ -0,0 +1,132 @@
+package couchdb_test
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cabify/go-couchdb"
+)
+
+func decodeJWT(t *testing.T, raw string) (header, payload map[string]interface{}, signingInput string, sig []byte) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		t.Fatalf("malformed token %q", raw)
+	}
+	for i, v := range []*map[string]interface{}{&header, &payload} {
+		js, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(js, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header, payload, parts[0] + "." + parts[1], sig
+}
+
+func TestJWTAuthStaticToken(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	auth := couchdb.JWTAuth(couchdb.StaticToken("a.b.c"))
+	auth.AddAuth(req)
+
+	expected := http.Header{"Authorization": {"Bearer a.b.c"}}
+	check(t, "req headers", expected, req.Header)
+}
+
+func TestHMACTokenSource(t *testing.T) {
+	key := []byte("secret")
+	src := couchdb.HMACTokenSource(key, couchdb.JWTClaims{
+		Subject: "user",
+		KeyID:   "k1",
+		Roles:   []string{"reader"},
+		TTL:     time.Minute,
+	})
+	tok, err := src.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, payload, in, sig := decodeJWT(t, tok.Raw)
+	check(t, "alg", "HS256", header["alg"])
+	check(t, "kid", "k1", header["kid"])
+	check(t, "sub", "user", payload["sub"])
+	check(t, "roles", []interface{}{"reader"}, payload["_couchdb.roles"])
+	check(t, "exp", float64(tok.Expiry.Unix()), payload["exp"])
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(in))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		t.Error("invalid signature")
+	}
+}
+
+func TestRSATokenSource(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := couchdb.RSATokenSource(key, couchdb.JWTClaims{Subject: "user", RolesClaim: "roles", Roles: []string{}})
+	tok, err := src.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, payload, in, sig := decodeJWT(t, tok.Raw)
+	check(t, "alg", "RS256", header["alg"])
+	check(t, "roles", []interface{}{}, payload["roles"])
+	sum := sha256.Sum256([]byte(in))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+		t.Errorf("invalid signature: %v", err)
+	}
+}
+
+type countingTokenSource struct {
+	calls int
+	err   error
+}
+
+func (s *countingTokenSource) Token() (*couchdb.Token, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &couchdb.Token{Raw: "token", Expiry: time.Now().Add(time.Hour)}, nil
+}
+
+func TestJWTAuthCachesToken(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("HEAD /", func(resp http.ResponseWriter, req *http.Request) {
+		check(t, "Authorization", "Bearer token", req.Header.Get("Authorization"))
+	})
+
+	src := new(countingTokenSource)
+	c.SetAuth(couchdb.JWTAuth(src))
+	for i := 0; i < 3; i++ {
+		if err := c.Ping(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(t, "token source calls", 1, src.calls)
+}
+
+func TestJWTAuthTokenError(t *testing.T) {
+	c := newTestClient(t)
+	c.SetAuth(couchdb.JWTAuth(&countingTokenSource{err: errors.New("no key")}))
+	// No handler: the request must fail before it is sent.
+	err := c.Ping()
+	if err == nil || !strings.Contains(err.Error(), "no key") {
+		t.Errorf("expected token source error, got %v", err)
+	}
+}
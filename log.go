@@ -0,0 +1,237 @@
+package couchdb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RequestLog describes a request for debug logging. Credentials in the
+// headers and bodies are redacted.
+type RequestLog struct {
+	Operation Operation
+	Method    string
+	Path      string     // Path of the request URL
+	Query     url.Values // Query options of the request
+	Header    http.Header
+
+	StatusCode     int // Zero if no response was received
+	ResponseHeader http.Header
+	Duration       time.Duration
+	ResponseSize   int64 // -1 if unknown
+	Err            error
+
+	// The bodies are only set if LogOptions.BodyLimit is positive, and
+	// truncated to that limit.
+	RequestBody  string
+	ResponseBody string
+}
+
+// Logger receives the RequestLog of each request.
+type Logger interface {
+	LogRequest(entry *RequestLog)
+}
+
+// LoggerFunc adapts a function to the Logger interface.
+type LoggerFunc func(entry *RequestLog)
+
+// LogRequest calls f(entry).
+func (f LoggerFunc) LogRequest(entry *RequestLog) {
+	f(entry)
+}
+
+// LogOptions configures LoggingMiddleware.
+type LogOptions struct {
+	// BodyLimit is the number of bytes of request and response bodies
+	// that are logged. Bodies are not logged if it is zero.
+	//
+	// With bodies, a request is only logged once its response body has
+	// been closed, so that the logged body and ResponseSize reflect what
+	// was read. Feeds are thus logged when they end.
+	BodyLimit int
+}
+
+const redacted = "[redacted]"
+
+// LoggingMiddleware returns a middleware that logs every request to
+// logger. Add it with Client.Use. The Authorization and
+// X-Auth-CouchDB-Token headers, AuthSession cookies and the bodies of
+// /_session requests are redacted.
+//
+//	logger := log.New(os.Stderr, "", log.LstdFlags)
+//	client.Use(couchdb.LoggingMiddleware(couchdb.StdLogger(logger), nil))
+func LoggingMiddleware(logger Logger, opts *LogOptions) Middleware {
+	var limit int
+	if opts != nil {
+		limit = opts.BodyLimit
+	}
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			entry := &RequestLog{
+				Method: req.Method,
+				Path:   req.URL.Path,
+				Query:  req.URL.Query(),
+				Header: redactHeader(req.Header),
+			}
+			entry.Operation, _ = OperationFromContext(req.Context())
+			session := strings.HasSuffix(req.URL.Path, "/_session")
+			if limit > 0 && req.GetBody != nil {
+				if session {
+					entry.RequestBody = redacted
+				} else if body, err := req.GetBody(); err == nil {
+					entry.RequestBody, _ = readLimit(body, limit)
+					body.Close()
+				}
+			}
+
+			start := time.Now()
+			resp, err := next(req)
+			entry.Duration, entry.Err, entry.ResponseSize = time.Since(start), err, -1
+			if err != nil {
+				if dberr, ok := err.(*Error); ok {
+					entry.StatusCode = dberr.StatusCode
+					entry.ResponseHeader = redactHeader(dberr.header)
+				}
+				logger.LogRequest(entry)
+				return resp, err
+			}
+			entry.StatusCode = resp.StatusCode
+			entry.ResponseHeader = redactHeader(resp.Header)
+			if limit <= 0 {
+				entry.ResponseSize = resp.ContentLength
+				logger.LogRequest(entry)
+				return resp, err
+			}
+			resp.Body = &loggedBody{
+				rc:     resp.Body,
+				limit:  limit,
+				redact: session,
+				entry:  entry,
+				logger: logger,
+				start:  start,
+			}
+			return resp, err
+		}
+	}
+}
+
+// loggedBody captures the start of a response body and logs the request
+// when the body is closed.
+type loggedBody struct {
+	rc     io.ReadCloser
+	limit  int
+	redact bool
+	buf    bytes.Buffer
+	size   int64
+	entry  *RequestLog
+	logger Logger
+	start  time.Time // of the request
+	once   sync.Once
+}
+
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	b.size += int64(n)
+	if rest := b.limit - b.buf.Len(); rest > 0 {
+		if rest > n {
+			rest = n
+		}
+		b.buf.Write(p[:rest])
+	}
+	return n, err
+}
+
+func (b *loggedBody) Close() error {
+	err := b.rc.Close()
+	b.once.Do(func() {
+		b.entry.ResponseSize = b.size
+		b.entry.Duration = time.Since(b.start)
+		if b.redact {
+			b.entry.ResponseBody = redacted
+		} else {
+			b.entry.ResponseBody = b.buf.String()
+		}
+		b.logger.LogRequest(b.entry)
+	})
+	return err
+}
+
+func readLimit(r io.Reader, limit int) (string, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r, int64(limit)))
+	return string(body), err
+}
+
+// redactHeader returns a copy of h without credentials.
+func redactHeader(h http.Header) http.Header {
+	if h == nil {
+		return nil
+	}
+	r := make(http.Header, len(h))
+	for k, vs := range h {
+		vs = append([]string(nil), vs...)
+		switch http.CanonicalHeaderKey(k) {
+		case "Authorization", "X-Auth-Couchdb-Token":
+			for i := range vs {
+				vs[i] = redacted
+			}
+		case "Cookie":
+			for i := range vs {
+				vs[i] = redactCookies(vs[i])
+			}
+		case "Set-Cookie":
+			for i, v := range vs {
+				if strings.HasPrefix(strings.TrimSpace(v), sessionCookieName+"=") {
+					vs[i] = sessionCookieName + "=" + redacted
+				}
+			}
+		}
+		r[k] = vs
+	}
+	return r
+}
+
+// redactCookies redacts the session cookie in the value of a Cookie header.
+func redactCookies(v string) string {
+	cookies := strings.Split(v, ";")
+	for i, c := range cookies {
+		if strings.HasPrefix(strings.TrimSpace(c), sessionCookieName+"=") {
+			cookies[i] = " " + sessionCookieName + "=" + redacted
+		}
+	}
+	return strings.TrimSpace(strings.Join(cookies, ";"))
+}
+
+// StdLogger writes one line per request to a standard library logger.
+func StdLogger(l *log.Logger) Logger {
+	return LoggerFunc(func(e *RequestLog) {
+		var b strings.Builder
+		fmt.Fprintf(&b, "couchdb: %s %s", e.Method, e.Path)
+		if len(e.Query) > 0 {
+			fmt.Fprintf(&b, "?%s", e.Query.Encode())
+		}
+		if e.StatusCode != 0 {
+			fmt.Fprintf(&b, " %d", e.StatusCode)
+		}
+		fmt.Fprintf(&b, " %v", e.Duration)
+		if e.ResponseSize >= 0 {
+			fmt.Fprintf(&b, " %dB", e.ResponseSize)
+		}
+		if e.Err != nil {
+			fmt.Fprintf(&b, " error=%q", e.Err.Error())
+		}
+		if e.RequestBody != "" {
+			fmt.Fprintf(&b, " request=%q", e.RequestBody)
+		}
+		if e.ResponseBody != "" {
+			fmt.Fprintf(&b, " response=%q", e.ResponseBody)
+		}
+		l.Print(b.String())
+	})
+}
@@ -0,0 +1,158 @@
+package couchdb_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/cabify/go-couchdb"
+)
+
+func TestLoggingRedactsCredentials(t *testing.T) {
+	c := newTestClient(t)
+	c.SetAuth(couchdb.ProxyAuth("user", []string{"admin"}, "secret"))
+	c.Handle("GET /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Add("Set-Cookie", "AuthSession=abc; Path=/; HttpOnly")
+		io.WriteString(resp, `{"_id":"doc"}`)
+	})
+
+	var entries []*couchdb.RequestLog
+	c.Use(func(next couchdb.RoundTripFunc) couchdb.RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			req.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+			req.Header.Set("Cookie", "other=1; AuthSession=abc")
+			return next(req)
+		}
+	}, couchdb.LoggingMiddleware(couchdb.LoggerFunc(func(e *couchdb.RequestLog) {
+		entries = append(entries, e)
+	}), nil))
+
+	if err := c.DB("db").Get("doc", new(testDocument), couchdb.Options{"revs": true}); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "number of entries", 1, len(entries))
+	e := entries[0]
+	check(t, "Operation", couchdb.Operation{Name: "Get", DB: "db"}, e.Operation)
+	check(t, "Method", "GET", e.Method)
+	check(t, "Path", "/db/doc", e.Path)
+	check(t, "Query", "true", e.Query.Get("revs"))
+	check(t, "StatusCode", 200, e.StatusCode)
+	check(t, "Authorization", "[redacted]", e.Header.Get("Authorization"))
+	check(t, "X-Auth-CouchDB-Token", "[redacted]", e.Header.Get("X-Auth-CouchDB-Token"))
+	check(t, "X-Auth-CouchDB-UserName", "user", e.Header.Get("X-Auth-CouchDB-UserName"))
+	check(t, "Cookie", "other=1; AuthSession=[redacted]", e.Header.Get("Cookie"))
+	check(t, "Set-Cookie", "AuthSession=[redacted]", e.ResponseHeader.Get("Set-Cookie"))
+}
+
+func TestLoggingBodies(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("POST /db/_find", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, `{"docs":[{"_id":"a"},{"_id":"b"}]}`)
+	})
+	c.Handle("GET /db/missing", func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusNotFound)
+		io.WriteString(resp, `{"error":"not_found","reason":"missing"}`)
+	})
+
+	var entries []*couchdb.RequestLog
+	c.Use(couchdb.LoggingMiddleware(couchdb.LoggerFunc(func(e *couchdb.RequestLog) {
+		entries = append(entries, e)
+	}), &couchdb.LogOptions{BodyLimit: 16}))
+
+	db := c.DB("db")
+	var docs []testDocument
+	if _, err := db.Find(&couchdb.FindQuery{Selector: map[string]interface{}{"_id": "a"}}, &docs); err != nil {
+		t.Fatal(err)
+	}
+	err := db.Get("missing", nil, nil)
+
+	check(t, "number of entries", 2, len(entries))
+	check(t, "RequestBody", `{"selector":{"_i`, entries[0].RequestBody)
+	check(t, "ResponseBody", `{"docs":[{"_id":`, entries[0].ResponseBody)
+	check(t, "ResponseSize", int64(34), entries[0].ResponseSize)
+	check(t, "StatusCode", 404, entries[1].StatusCode)
+	check(t, "Err", err, entries[1].Err)
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := couchdb.StdLogger(log.New(&buf, "", 0))
+	logger.LogRequest(&couchdb.RequestLog{
+		Method:       "GET",
+		Path:         "/db/doc",
+		StatusCode:   200,
+		ResponseSize: 12,
+	})
+	check(t, "log line", "couchdb: GET /db/doc 200 0s 12B", strings.TrimSpace(buf.String()))
+}
+
+func TestLoggingSecurity(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_security", func(resp http.ResponseWriter, req *http.Request) {})
+	c.Handle("PUT /db/_security", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, `{"ok":true}`)
+	})
+
+	var entries []*couchdb.RequestLog
+	c.Use(couchdb.LoggingMiddleware(couchdb.LoggerFunc(func(e *couchdb.RequestLog) {
+		entries = append(entries, e)
+	}), &couchdb.LogOptions{BodyLimit: 16}))
+
+	if _, err := c.DB("db").Security(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DB("db").PutSecurity(&couchdb.Security{}); err != nil {
+		t.Fatal(err)
+	}
+	var sizes []int64
+	for _, e := range entries {
+		sizes = append(sizes, e.ResponseSize)
+	}
+	check(t, "ResponseSize of Security and PutSecurity", []int64{0, 11}, sizes)
+}
+
+func TestLoggingRenewedSession(t *testing.T) {
+	c := newTestClient(t)
+	logins := 0
+	c.Handle("POST /_session", func(resp http.ResponseWriter, req *http.Request) {
+		logins++
+		http.SetCookie(resp, &http.Cookie{Name: "AuthSession", Value: fmt.Sprint("session", logins)})
+		io.WriteString(resp, `{"ok":true}`)
+	})
+	c.Handle("GET /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		if cookie, _ := req.Cookie("AuthSession"); cookie.Value == "session1" {
+			resp.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(resp, `{"error":"unauthorized","reason":"session expired"}`)
+			return
+		}
+		io.WriteString(resp, `{"_id":"doc"}`)
+	})
+
+	var (
+		cookies []string
+		entries []*couchdb.RequestLog
+	)
+	c.SetAuth(couchdb.CookieAuth("user", "password"))
+	c.Use(couchdb.LoggingMiddleware(couchdb.LoggerFunc(func(e *couchdb.RequestLog) {
+		entries = append(entries, e)
+	}), nil), func(next couchdb.RoundTripFunc) couchdb.RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			cookies = append(cookies, req.Header.Get("Cookie"))
+			return next(req)
+		}
+	})
+
+	if err := c.DB("db").Get("doc", new(testDocument), nil); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "cookies of attempts", []string{"AuthSession=session1", "AuthSession=session2"}, cookies)
+	var codes []int
+	for _, e := range entries {
+		codes = append(codes, e.StatusCode)
+	}
+	check(t, "status codes of entries", []int{401, 200}, codes)
+}
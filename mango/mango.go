@@ -0,0 +1,199 @@
+// Package mango builds CouchDB Mango selectors.
+//
+// Selectors marshal into the JSON expected by CouchDB, so they can be
+// used wherever a selector goes, e.g. in a couchdb.FindQuery or in the
+// body of a changes feed filtered by _selector:
+//
+//	sel := mango.And(
+//		mango.Eq("type", "order"),
+//		mango.Gt(mango.Path("total", "amount"), 100),
+//		mango.ElemMatch("tags", mango.Eq("", "priority")),
+//	)
+//	res, err := db.Find(&couchdb.FindQuery{Selector: sel}, &docs)
+//	...
+//	feed, err := db.ContinuousChangesWithBody(couchdb.Options{"filter": "_selector"},
+//		map[string]interface{}{"selector": sel})
+//
+// Invalid operator arguments are detected on the client: the error is
+// returned by Err and by json.Marshal, so the query fails before it is
+// sent to the server.
+//
+// http://docs.couchdb.org/en/latest/api/database/find.html#selector-syntax
+package mango
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Selector is a Mango selector. The zero value matches all documents.
+type Selector struct {
+	field string      // empty for combination operators and bare conditions
+	op    string      // empty for the zero value
+	arg   interface{} // a Selector, []Selector or a plain value
+	err   error
+}
+
+// Err returns the first error found while building the selector.
+func (s Selector) Err() error {
+	return s.err
+}
+
+// MarshalJSON encodes the selector, or fails with the error returned by Err.
+func (s Selector) MarshalJSON() ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.op == "" {
+		return []byte("{}"), nil
+	}
+	cond := map[string]interface{}{s.op: s.arg}
+	if s.field == "" {
+		return json.Marshal(cond)
+	}
+	return json.Marshal(map[string]interface{}{s.field: cond})
+}
+
+// Path joins the parts of a field path with dots. Dots within a part
+// are escaped, so that they are not taken as a path separator.
+func Path(parts ...string) string {
+	escaped := make([]string, len(parts))
+	for i, part := range parts {
+		escaped[i] = strings.Replace(part, ".", `\.`, -1)
+	}
+	return strings.Join(escaped, ".")
+}
+
+func cond(field, op string, arg interface{}) Selector {
+	return Selector{field: field, op: op, arg: arg}
+}
+
+func invalid(op string, format string, args ...interface{}) Selector {
+	return Selector{err: fmt.Errorf("mango: %s: %s", op, fmt.Sprintf(format, args...))}
+}
+
+// combine builds a combination operator over a list of selectors.
+func combine(op string, sels []Selector) Selector {
+	if len(sels) == 0 {
+		return invalid(op, "needs at least one selector")
+	}
+	for _, sel := range sels {
+		if sel.err != nil {
+			return Selector{err: sel.err}
+		}
+	}
+	return cond("", op, append([]Selector(nil), sels...))
+}
+
+// nested builds an operator that takes a single selector as argument.
+func nested(field, op string, sel Selector) Selector {
+	if sel.err != nil {
+		return Selector{err: sel.err}
+	}
+	return cond(field, op, sel)
+}
+
+// list builds an operator that takes an array of values as argument.
+func list(field, op string, min int, vals []interface{}) Selector {
+	if len(vals) < min {
+		return invalid(op, "needs at least %d values, got %d", min, len(vals))
+	}
+	if vals == nil {
+		vals = []interface{}{}
+	}
+	return cond(field, op, vals)
+}
+
+// And matches documents that match all the given selectors.
+func And(sels ...Selector) Selector { return combine("$and", sels) }
+
+// Or matches documents that match any of the given selectors.
+func Or(sels ...Selector) Selector { return combine("$or", sels) }
+
+// Nor matches documents that match none of the given selectors.
+func Nor(sels ...Selector) Selector { return combine("$nor", sels) }
+
+// Not matches documents that do not match sel.
+func Not(sel Selector) Selector { return nested("", "$not", sel) }
+
+// Eq matches documents whose field equals v.
+// Use an empty field inside ElemMatch and AllMatch to refer to
+// the array elements themselves.
+func Eq(field string, v interface{}) Selector { return cond(field, "$eq", v) }
+
+// Ne matches documents whose field is not equal to v.
+func Ne(field string, v interface{}) Selector { return cond(field, "$ne", v) }
+
+// Lt matches documents whose field is less than v.
+func Lt(field string, v interface{}) Selector { return cond(field, "$lt", v) }
+
+// Lte matches documents whose field is less than or equal to v.
+func Lte(field string, v interface{}) Selector { return cond(field, "$lte", v) }
+
+// Gt matches documents whose field is greater than v.
+func Gt(field string, v interface{}) Selector { return cond(field, "$gt", v) }
+
+// Gte matches documents whose field is greater than or equal to v.
+func Gte(field string, v interface{}) Selector { return cond(field, "$gte", v) }
+
+// In matches documents whose field equals one of vals.
+func In(field string, vals ...interface{}) Selector { return list(field, "$in", 1, vals) }
+
+// Nin matches documents whose field equals none of vals.
+func Nin(field string, vals ...interface{}) Selector { return list(field, "$nin", 0, vals) }
+
+// All matches documents whose array field contains all of vals.
+func All(field string, vals ...interface{}) Selector { return list(field, "$all", 1, vals) }
+
+// Exists matches documents that have (or, if exists is false,
+// do not have) the field.
+func Exists(field string, exists bool) Selector { return cond(field, "$exists", exists) }
+
+// Type matches documents whose field is of the given JSON type:
+// "null", "boolean", "number", "string", "array" or "object".
+func Type(field, typ string) Selector {
+	switch typ {
+	case "null", "boolean", "number", "string", "array", "object":
+		return cond(field, "$type", typ)
+	}
+	return invalid("$type", "unknown type %q", typ)
+}
+
+// Size matches documents whose array field has exactly n elements.
+func Size(field string, n int) Selector {
+	if n < 0 {
+		return invalid("$size", "negative size %d", n)
+	}
+	return cond(field, "$size", n)
+}
+
+// Mod matches documents whose integer field leaves remainder
+// when divided by divisor.
+func Mod(field string, divisor, remainder int64) Selector {
+	if divisor == 0 {
+		return invalid("$mod", "divisor must not be zero")
+	}
+	return cond(field, "$mod", []int64{divisor, remainder})
+}
+
+// Regex matches documents whose string field matches the
+// (Erlang PCRE) regular expression pattern.
+func Regex(field, pattern string) Selector {
+	if pattern == "" {
+		return invalid("$regex", "empty pattern")
+	}
+	return cond(field, "$regex", pattern)
+}
+
+// ElemMatch matches documents whose array field has at least
+// one element matching sel.
+func ElemMatch(field string, sel Selector) Selector { return nested(field, "$elemMatch", sel) }
+
+// AllMatch matches documents whose array field has only
+// elements matching sel.
+func AllMatch(field string, sel Selector) Selector { return nested(field, "$allMatch", sel) }
+
+// KeyMapMatch matches documents whose object field has at least
+// one key matching sel.
+func KeyMapMatch(field string, sel Selector) Selector { return nested(field, "$keyMapMatch", sel) }
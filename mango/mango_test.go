@@ -0,0 +1,75 @@
+package mango_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/cabify/go-couchdb/mango"
+)
+
+func TestSelectorJSON(t *testing.T) {
+	tests := []struct {
+		sel  mango.Selector
+		json string
+	}{
+		{mango.Selector{}, `{}`},
+		{mango.Eq("name", "bob"), `{"name":{"$eq":"bob"}}`},
+		{mango.Gt(mango.Path("total", "amount"), 100), `{"total.amount":{"$gt":100}}`},
+		{mango.Lte(mango.Path("a.b", "c"), 1), `{"a\\.b.c":{"$lte":1}}`},
+		{mango.In("type", "a", "b"), `{"type":{"$in":["a","b"]}}`},
+		{mango.Nin("type"), `{"type":{"$nin":[]}}`},
+		{mango.Exists("deleted_at", false), `{"deleted_at":{"$exists":false}}`},
+		{mango.Type("tags", "array"), `{"tags":{"$type":"array"}}`},
+		{mango.Size("tags", 2), `{"tags":{"$size":2}}`},
+		{mango.Mod("n", 4, 1), `{"n":{"$mod":[4,1]}}`},
+		{mango.Regex("name", "^b"), `{"name":{"$regex":"^b"}}`},
+		{
+			mango.And(mango.Eq("type", "order"), mango.Or(mango.Lt("n", 1), mango.Gte("n", 10))),
+			`{"$and":[{"type":{"$eq":"order"}},{"$or":[{"n":{"$lt":1}},{"n":{"$gte":10}}]}]}`,
+		},
+		{mango.Not(mango.Ne("a", nil)), `{"$not":{"a":{"$ne":null}}}`},
+		{mango.Nor(mango.Eq("a", 1)), `{"$nor":[{"a":{"$eq":1}}]}`},
+		{mango.ElemMatch("tags", mango.Eq("", "x")), `{"tags":{"$elemMatch":{"$eq":"x"}}}`},
+		{mango.AllMatch("items", mango.Gt("qty", 0)), `{"items":{"$allMatch":{"qty":{"$gt":0}}}}`},
+		{mango.KeyMapMatch("m", mango.Eq("", "k")), `{"m":{"$keyMapMatch":{"$eq":"k"}}}`},
+		{mango.All("tags", "a"), `{"tags":{"$all":["a"]}}`},
+	}
+
+	for _, test := range tests {
+		js, err := json.Marshal(test.sel)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.json, err)
+			continue
+		}
+		if string(js) != test.json {
+			t.Errorf("JSON mismatch:\nwant %s\ngot  %s", test.json, js)
+		}
+	}
+}
+
+func TestSelectorArity(t *testing.T) {
+	tests := []mango.Selector{
+		mango.And(),
+		mango.Or(),
+		mango.Nor(),
+		mango.In("type"),
+		mango.All("tags"),
+		mango.Type("a", "int"),
+		mango.Size("a", -1),
+		mango.Mod("a", 0, 1),
+		mango.Regex("a", ""),
+		// errors propagate to the enclosing selectors
+		mango.And(mango.Eq("a", 1), mango.Or()),
+		mango.Not(mango.In("a")),
+		mango.ElemMatch("a", mango.And()),
+	}
+
+	for i, sel := range tests {
+		if sel.Err() == nil {
+			t.Errorf("test %d: expected an error", i)
+		}
+		if _, err := json.Marshal(map[string]interface{}{"selector": sel}); err == nil {
+			t.Errorf("test %d: expected json.Marshal to fail", i)
+		}
+	}
+}
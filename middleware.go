@@ -0,0 +1,65 @@
+package couchdb
+
+import "net/http"
+
+// RoundTripFunc sends a request to CouchDB. Responses with status codes
+// >= 400 are returned as *Error, with the response body already consumed.
+type RoundTripFunc func(req *http.Request) (*http.Response, error)
+
+// Middleware wraps the RoundTripFunc that sends a request. A middleware
+// can modify the request before it calls next, inspect or replace the
+// response or error returned by next, or not call next at all.
+//
+//	func requestID(next couchdb.RoundTripFunc) couchdb.RoundTripFunc {
+//		return func(req *http.Request) (*http.Response, error) {
+//			req.Header.Set("X-Request-ID", newID())
+//			return next(req)
+//		}
+//	}
+type Middleware func(next RoundTripFunc) RoundTripFunc
+
+// Use adds middleware to the client. The first middleware added is the
+// outermost one, it sees the request first and the response last.
+//
+// Middleware sees each attempt of a request, after credentials have been
+// added and before failed attempts are retried according to the retry
+// policy. When a session of CookieAuth has expired, the request that was
+// rejected and the one sent again with the renewed session are separate
+// attempts. Requests made by Auth implementations to obtain credentials,
+// like the login of CookieAuth, are not passed to middleware.
+func (c *Client) Use(mw ...Middleware) {
+	c.transport.use(mw)
+}
+
+func (t *transport) use(mw []Middleware) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// copy, so that chains built before are not modified
+	t.middleware = append(append([]Middleware(nil), t.middleware...), mw...)
+}
+
+// chain returns the RoundTripFunc for one attempt of a request,
+// wrapped in all middleware.
+func (t *transport) chain() RoundTripFunc {
+	t.mu.RLock()
+	mw := t.middleware
+	t.mu.RUnlock()
+	rt := t.attempt
+	for i := len(mw) - 1; i >= 0; i-- {
+		rt = mw[i](rt)
+	}
+	return rt
+}
+
+// attempt sends req once and turns error responses into *Error.
+func (t *transport) attempt(req *http.Request) (*http.Response, error) {
+	resp, err := t.roundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.observe(resp)
+	if resp.StatusCode >= 400 {
+		return nil, parseError(resp) // the Body is closed by parseError
+	}
+	return resp, nil
+}
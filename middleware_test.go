@@ -0,0 +1,98 @@
+package couchdb_test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cabify/go-couchdb"
+)
+
+func TestMiddlewareOrder(t *testing.T) {
+	c := newTestClient(t)
+	c.SetAuth(couchdb.BasicAuth("user", "pass"))
+	c.Handle("GET /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		check(t, "X-Request-ID", "42", req.Header.Get("X-Request-ID"))
+		check(t, "X-Tenant", "acme", req.Header.Get("X-Tenant"))
+		io.WriteString(resp, `{"_id":"doc"}`)
+	})
+
+	var calls []string
+	named := func(name string, header, value string) couchdb.Middleware {
+		return func(next couchdb.RoundTripFunc) couchdb.RoundTripFunc {
+			return func(req *http.Request) (*http.Response, error) {
+				calls = append(calls, name+" request")
+				if _, _, ok := req.BasicAuth(); !ok {
+					t.Errorf("%s: request without credentials", name)
+				}
+				req.Header.Set(header, value)
+				resp, err := next(req)
+				calls = append(calls, name+" response")
+				return resp, err
+			}
+		}
+	}
+	c.Use(named("outer", "X-Request-ID", "42"))
+	c.Use(named("inner", "X-Tenant", "acme"))
+
+	if err := c.DB("db").Get("doc", new(testDocument), nil); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "calls", []string{"outer request", "inner request", "inner response", "outer response"}, calls)
+}
+
+func TestMiddlewareSeesError(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusNotFound)
+		io.WriteString(resp, `{"error":"not_found","reason":"missing"}`)
+	})
+
+	var seen error
+	c.Use(func(next couchdb.RoundTripFunc) couchdb.RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			resp, err := next(req)
+			seen = err
+			return resp, err
+		}
+	})
+
+	err := c.DB("db").Get("doc", nil, nil)
+	check(t, "couchdb.NotFound(err)", true, couchdb.NotFound(err))
+	check(t, "seen", err, seen)
+	if dberr, ok := seen.(*couchdb.Error); !ok || dberr.ErrorCode != "not_found" {
+		t.Errorf("middleware saw %#v, want *Error not_found", seen)
+	}
+}
+
+func TestMiddlewareFaultInjection(t *testing.T) {
+	c := newTestClient(t)
+	c.SetRetryPolicy(&couchdb.RetryPolicy{MinBackoff: time.Millisecond})
+	requests := 0
+	c.Handle("GET /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		requests++
+		io.WriteString(resp, `{"_id":"doc"}`)
+	})
+
+	injected := 0
+	c.Use(func(next couchdb.RoundTripFunc) couchdb.RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			if injected < 2 {
+				injected++
+				return nil, &couchdb.Error{
+					Method:     req.Method,
+					URL:        req.URL.String(),
+					StatusCode: http.StatusServiceUnavailable,
+				}
+			}
+			return next(req)
+		}
+	})
+
+	if err := c.DB("db").Get("doc", new(testDocument), nil); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "injected faults", 2, injected)
+	check(t, "requests", 1, requests)
+}
@@ -0,0 +1,165 @@
+package couchdb
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+)
+
+// processBacklog is the number of changes per worker that may be
+// dispatched but not yet acknowledged.
+const processBacklog = 16
+
+// Process handles the changes of the subscription with the given number
+// of concurrent workers. Changes are assigned to workers by document ID,
+// so that the changes of a document are handled one after another and in
+// the order of the feed. Changes of different documents may be handled
+// in any order.
+//
+// A change is only acknowledged once it and all changes before it
+// have been handled, so the checkpoint never skips a change that has
+// not been handled yet.
+//
+// Process returns when the subscription ends, with the same error as
+// Err, or when handler returns an error. Changes that are pending at that
+// point are not handled. The caller should then cancel the context of
+// the subscription. Process cannot be used together with AutoAck.
+func (s *Subscription) Process(workers int, handler func(Change) error) error {
+	if s.autoAck {
+		return errors.New("couchdb: Process cannot be used with AutoAck")
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	p := &processor{
+		sub:     s,
+		handler: handler,
+		inputs:  make([]chan processItem, workers),
+		done:    make(chan processItem, workers*processBacklog),
+		quit:    make(chan struct{}),
+		changes: make(map[int]Change),
+		handled: make(map[int]bool),
+	}
+	for i := range p.inputs {
+		p.inputs[i] = make(chan processItem, workers*processBacklog)
+		p.wg.Add(1)
+		go p.work(p.inputs[i])
+	}
+	return p.run(workers * processBacklog)
+}
+
+var errSkipped = errors.New("couchdb: change skipped")
+
+type processItem struct {
+	n      int
+	change Change
+	err    error
+}
+
+type processor struct {
+	sub     *Subscription
+	handler func(Change) error
+	inputs  []chan processItem
+	done    chan processItem
+	quit    chan struct{}
+	wg      sync.WaitGroup
+
+	changes map[int]Change // dispatched changes by number
+	handled map[int]bool   // handled changes, ahead of next
+	next    int            // number of the first unhandled change
+}
+
+func (p *processor) run(backlog int) error {
+	var (
+		in      = p.sub.C
+		n       int
+		pending int
+		err     error
+		failed  bool
+	)
+	fail := func(e error) {
+		if !failed {
+			failed, err, in = true, e, nil
+			close(p.quit)
+		}
+	}
+	for in != nil || pending > 0 {
+		// Stop reading while the backlog is full, so that neither the
+		// inputs nor the done channel can ever block.
+		recv := in
+		if pending >= backlog {
+			recv = nil
+		}
+		select {
+		case c, ok := <-recv:
+			if !ok {
+				in, err = nil, p.sub.Err()
+				continue
+			}
+			p.changes[n] = c
+			p.inputs[workerOf(c.ID, len(p.inputs))] <- processItem{n: n, change: c}
+			n++
+			pending++
+		case item := <-p.done:
+			pending--
+			if item.err == nil {
+				item.err = p.handle(item.n)
+			}
+			if item.err != nil {
+				fail(item.err)
+			}
+		}
+	}
+	for _, input := range p.inputs {
+		close(input)
+	}
+	p.wg.Wait()
+	// Acks after the end of the subscription are not committed by it.
+	if cerr := p.sub.Commit(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// handle marks change n as handled and acknowledges the last change
+// before the first unhandled one.
+func (p *processor) handle(n int) error {
+	p.handled[n] = true
+	last := -1
+	for p.handled[p.next] {
+		delete(p.handled, p.next)
+		last = p.next
+		p.next++
+	}
+	if last < 0 {
+		return nil
+	}
+	c := p.changes[last]
+	for i := last; i >= 0; i-- {
+		if _, ok := p.changes[i]; !ok {
+			break
+		}
+		delete(p.changes, i)
+	}
+	return p.sub.Ack(c)
+}
+
+func (p *processor) work(input <-chan processItem) {
+	defer p.wg.Done()
+	for item := range input {
+		select {
+		case <-p.quit:
+			// Skip the remaining changes after a failure.
+			item.err = errSkipped
+		default:
+			item.err = p.handler(item.change)
+		}
+		p.done <- item
+	}
+}
+
+func workerOf(id string, workers int) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % uint32(workers))
+}
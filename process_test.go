@@ -0,0 +1,133 @@
+package couchdb_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/cabify/go-couchdb"
+)
+
+func newProcessTestClient(t *testing.T, changes string) (*testClient, func() []string) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_local/proc", func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusNotFound)
+	})
+	c.Handle("GET /db/_changes", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, changes)
+	})
+	var (
+		mu   sync.Mutex
+		puts []string
+	)
+	c.Handle("PUT /db/_local/proc", func(resp http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		mu.Lock()
+		puts = append(puts, string(body))
+		mu.Unlock()
+		resp.WriteHeader(http.StatusCreated)
+		io.WriteString(resp, `{"ok":true,"id":"_local/proc","rev":"0-1"}`)
+	})
+	return c, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), puts...)
+	}
+}
+
+func TestSubscriptionProcess(t *testing.T) {
+	// With two workers, "a" is handled by one, "b" and "d" by the other.
+	c, puts := newProcessTestClient(t, `{"seq":"1","id":"a"}
+		{"seq":"2","id":"b"}
+		{"seq":"3","id":"a"}
+		{"seq":"4","id":"d"}
+		{"last_seq":"4"}
+	`)
+	sub, err := c.DB("db").Subscribe(context.Background(), "proc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu       sync.Mutex
+		handled  []string
+		others   sync.WaitGroup
+		release  = make(chan struct{})
+		released bool
+	)
+	others.Add(2)
+	go func() {
+		others.Wait()
+		mu.Lock()
+		check(t, "checkpoint writes before the first change is handled", 0, len(puts()))
+		released = true
+		mu.Unlock()
+		close(release)
+	}()
+	err = sub.Process(2, func(change couchdb.Change) error {
+		switch change.Seq {
+		case "1":
+			<-release
+		case "2", "4":
+			defer others.Done()
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, change.ID+change.Seq.(string))
+		return nil
+	})
+	check(t, "sub.Process()", error(nil), err)
+	check(t, "released", true, released)
+
+	var ofA []string
+	for _, h := range handled {
+		if h[0] == 'a' {
+			ofA = append(ofA, h)
+		}
+	}
+	check(t, "changes of a", []string{"a1", "a3"}, ofA)
+	check(t, "handled changes", 4, len(handled))
+	writes := puts()
+	check(t, "last checkpoint write", `{"_id":"_local/proc","_rev":"0-1","seq":"4"}`, writes[len(writes)-1])
+	check(t, "sub.Checkpoint()", "4", sub.Checkpoint())
+}
+
+func TestSubscriptionProcessError(t *testing.T) {
+	c, puts := newProcessTestClient(t, `{"seq":"1","id":"a"}
+		{"seq":"2","id":"b"}
+		{"seq":"3","id":"c"}
+		{"last_seq":"3"}
+	`)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub, err := c.DB("db").Subscribe(ctx, "proc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failure := errors.New("failure")
+	err = sub.Process(1, func(change couchdb.Change) error {
+		if change.ID == "b" {
+			return failure
+		}
+		return nil
+	})
+	check(t, "sub.Process()", failure, err)
+	check(t, "checkpoint writes", []string{`{"_id":"_local/proc","seq":"1"}`}, puts())
+	check(t, "sub.Checkpoint()", "1", sub.Checkpoint())
+}
+
+func TestSubscriptionProcessAutoAck(t *testing.T) {
+	c, _ := newProcessTestClient(t, `{"last_seq":"0"}`)
+	sub, err := c.DB("db").Subscribe(context.Background(), "proc", &couchdb.SubscribeOptions{AutoAck: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Process(1, func(couchdb.Change) error { return nil }); err == nil {
+		t.Error("expected an error for Process with AutoAck")
+	}
+}
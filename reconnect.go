@@ -0,0 +1,200 @@
+package couchdb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStalled is returned by feeds that received neither data nor
+// heartbeats for longer than their stall timeout.
+var ErrStalled = errors.New("couchdb: feed stalled, heartbeat missed")
+
+// ReconnectOptions configures feeds that reconnect automatically after
+// the connection dropped or stalled.
+// The zero value uses the defaults noted on each field.
+type ReconnectOptions struct {
+	// Heartbeat is the interval in which CouchDB is asked to send
+	// a newline on an idle feed. Defaults to 10 seconds.
+	Heartbeat time.Duration
+
+	// StallTimeout is the time a read of the feed waits for any data,
+	// heartbeats included, after which the connection is considered
+	// stalled and dropped. Time spent between calls to Next doesn't
+	// count. Defaults to three heartbeats.
+	StallTimeout time.Duration
+
+	// MinBackoff and MaxBackoff limit the time waited before reconnecting.
+	// The wait starts at MinBackoff and doubles after every failed attempt
+	// up to MaxBackoff. They default to 500 milliseconds and 30 seconds.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// MaxAttempts is the number of consecutive failed attempts after which
+	// the feed gives up and returns the last error. Zero means no limit.
+	MaxAttempts int
+}
+
+const (
+	defaultHeartbeat  = 10 * time.Second
+	defaultMinBackoff = 500 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+)
+
+// withDefaults returns a copy of the options with all defaults applied.
+func (o *ReconnectOptions) withDefaults() ReconnectOptions {
+	var r ReconnectOptions
+	if o != nil {
+		r = *o
+	}
+	if r.Heartbeat <= 0 {
+		r.Heartbeat = defaultHeartbeat
+	}
+	if r.StallTimeout <= 0 {
+		r.StallTimeout = 3 * r.Heartbeat
+	}
+	r.MinBackoff, r.MaxBackoff = backoffLimits(r.MinBackoff, r.MaxBackoff,
+		defaultMinBackoff, defaultMaxBackoff)
+	return r
+}
+
+// backoff returns the time to wait before the given attempt, counted from 1.
+func (o *ReconnectOptions) backoff(attempt int) time.Duration {
+	return expBackoff(attempt, o.MinBackoff, o.MaxBackoff, 2, false)
+}
+
+// retryable reports whether a feed should reconnect after err.
+// Errors reported by CouchDB are only retried for server side failures.
+func retryable(err error) bool {
+	if dberr, ok := err.(*Error); ok {
+		return dberr.StatusCode >= 500 ||
+			dberr.StatusCode == http.StatusTooManyRequests ||
+			dberr.StatusCode == http.StatusRequestTimeout
+	}
+	return true
+}
+
+// reconnector reopens a feed connection with backoff. It is shared by
+// the resilient feeds.
+type reconnector struct {
+	ctx      context.Context
+	opts     ReconnectOptions
+	attempts int
+	done     chan struct{}
+	closed   int32
+
+	since  interface{} // Seq of the last complete event
+	reopen func(since interface{}) (io.ReadCloser, error)
+}
+
+// newReconnector creates a reconnector that gives up once ctx is done.
+func newReconnector(ctx context.Context, opts *ReconnectOptions) *reconnector {
+	return &reconnector{ctx: ctx, opts: opts.withDefaults(), done: make(chan struct{})}
+}
+
+// next reads the next event of a resilient feed with parse. When parse
+// fails, the connection is reopened since the last event and handed to
+// replace, and parse is called again, until reconnecting gives up.
+func (r *reconnector) next(parse func() error, replace func(conn io.ReadCloser)) error {
+	err := parse()
+	for err != nil {
+		err = r.reconnect(err, func() error {
+			conn, err := r.reopen(r.since)
+			if err != nil {
+				return err
+			}
+			replace(conn)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = parse()
+	}
+	return nil
+}
+
+// reconnect calls open until it succeeds, the attempts are exhausted,
+// a non retryable error occurs or the feed is closed. The cause is the
+// error that broke the previous connection.
+func (r *reconnector) reconnect(cause error, open func() error) error {
+	err := cause
+	for r.isOpen() && retryable(err) {
+		r.attempts++
+		if r.opts.MaxAttempts > 0 && r.attempts > r.opts.MaxAttempts {
+			return err
+		}
+		select {
+		case <-time.After(r.opts.backoff(r.attempts)):
+		case <-r.done:
+			return cause
+		case <-r.ctx.Done():
+			return r.ctx.Err()
+		}
+		if err = open(); err == nil {
+			return nil
+		}
+	}
+	if ctxErr := r.ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// success resets the backoff after the feed delivered an event. A
+// reconnected feed resumes after seq, unless it is nil.
+func (r *reconnector) success(seq interface{}) {
+	if seq != nil {
+		r.since = seq
+	}
+	r.attempts = 0
+}
+
+func (r *reconnector) isOpen() bool {
+	return atomic.LoadInt32(&r.closed) == 0 && r.ctx.Err() == nil
+}
+
+// close stops any pending and future reconnection attempts.
+func (r *reconnector) close() {
+	if atomic.CompareAndSwapInt32(&r.closed, 0, 1) {
+		close(r.done)
+	}
+}
+
+// stallReader closes the underlying connection when a read is blocked
+// for longer than the timeout, so that it returns ErrStalled. The time
+// spent by the caller between reads doesn't count.
+type stallReader struct {
+	rc      io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	stalled int32
+}
+
+func newStallReader(rc io.ReadCloser, timeout time.Duration) *stallReader {
+	r := &stallReader{rc: rc, timeout: timeout}
+	r.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&r.stalled, 1)
+		rc.Close()
+	})
+	r.timer.Stop()
+	return r
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	r.timer.Reset(r.timeout)
+	n, err := r.rc.Read(p)
+	r.timer.Stop()
+	if err != nil && atomic.LoadInt32(&r.stalled) == 1 {
+		err = ErrStalled
+	}
+	return n, err
+}
+
+func (r *stallReader) Close() error {
+	r.timer.Stop()
+	return r.rc.Close()
+}
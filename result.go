@@ -0,0 +1,149 @@
+package couchdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// RowError is an error reported for a single row of a view or
+// _all_docs result.
+type RowError struct {
+	Key       json.RawMessage // Key of the row
+	ErrorCode string          // Error reason provided by CouchDB
+	Reason    string          // Error message provided by CouchDB
+}
+
+func (e *RowError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("couchdb: row %s: %s", e.Key, e.ErrorCode)
+	}
+	return fmt.Sprintf("couchdb: row %s: %s: %s", e.Key, e.ErrorCode, e.Reason)
+}
+
+// ViewResult is the result of a view query. It can be passed as result
+// to View, PostView, QueryView and AllDocs.
+type ViewResult struct {
+	TotalRows int         `json:"total_rows"`
+	Offset    int         `json:"offset"`
+	UpdateSeq interface{} `json:"update_seq,omitempty"` // Only set if the option "update_seq" is true
+	Rows      []Row       `json:"rows"`
+}
+
+// Err returns the error of the first row that has one.
+func (r *ViewResult) Err() error {
+	for i := range r.Rows {
+		if err := r.Rows[i].Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DecodeValues unmarshals the values of all rows into v, which should
+// be a pointer to a slice.
+func (r *ViewResult) DecodeValues(v interface{}) error {
+	raws := make([]json.RawMessage, 0, len(r.Rows))
+	for _, row := range r.Rows {
+		if row.Error == "" {
+			raws = append(raws, row.Value)
+		}
+	}
+	return decodeRaws(raws, v)
+}
+
+// DecodeDocs unmarshals the documents of all rows into v, which should
+// be a pointer to a slice. Rows without a document are skipped.
+func (r *ViewResult) DecodeDocs(v interface{}) error {
+	raws := make([]json.RawMessage, 0, len(r.Rows))
+	for _, row := range r.Rows {
+		raws = appendDoc(raws, row.Doc)
+	}
+	return decodeRaws(raws, v)
+}
+
+// AllDocsRow is a single row of an _all_docs result.
+type AllDocsRow struct {
+	ID    string `json:"id,omitempty"`
+	Key   string `json:"key"`
+	Value struct {
+		Rev     string `json:"rev"`
+		Deleted bool   `json:"deleted,omitempty"`
+	} `json:"value"`
+
+	// The document. This is populated only if the option
+	// "include_docs" is true, it is null for deleted documents.
+	Doc json.RawMessage `json:"doc,omitempty"`
+
+	// Error is "not_found" for requested keys that don't exist.
+	Error  string `json:"error,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// AllDocsResult is the result of an _all_docs query. It can be passed
+// as result to AllDocs, PostAllDocs and QueryAllDocs.
+type AllDocsResult struct {
+	TotalRows int          `json:"total_rows"`
+	Offset    int          `json:"offset"`
+	UpdateSeq interface{}  `json:"update_seq,omitempty"` // Only set if the option "update_seq" is true
+	Rows      []AllDocsRow `json:"rows"`
+}
+
+// Err returns the first row error other than "not_found".
+// Use Missing to find the keys that were not found.
+func (r *AllDocsResult) Err() error {
+	for _, row := range r.Rows {
+		if row.Error != "" && row.Error != "not_found" {
+			key, _ := json.Marshal(row.Key)
+			return &RowError{Key: key, ErrorCode: row.Error, Reason: row.Reason}
+		}
+	}
+	return nil
+}
+
+// Missing returns the requested keys that don't exist.
+func (r *AllDocsResult) Missing() []string {
+	var keys []string
+	for _, row := range r.Rows {
+		if row.Error == "not_found" {
+			keys = append(keys, row.Key)
+		}
+	}
+	return keys
+}
+
+// Deleted returns the IDs of the requested documents that were deleted.
+func (r *AllDocsResult) Deleted() []string {
+	var ids []string
+	for _, row := range r.Rows {
+		if row.Value.Deleted {
+			ids = append(ids, row.ID)
+		}
+	}
+	return ids
+}
+
+// DecodeDocs unmarshals the documents of all rows into v, which should
+// be a pointer to a slice. Missing and deleted documents are skipped.
+func (r *AllDocsResult) DecodeDocs(v interface{}) error {
+	raws := make([]json.RawMessage, 0, len(r.Rows))
+	for _, row := range r.Rows {
+		raws = appendDoc(raws, row.Doc)
+	}
+	return decodeRaws(raws, v)
+}
+
+func appendDoc(raws []json.RawMessage, doc json.RawMessage) []json.RawMessage {
+	if len(doc) == 0 || bytes.Equal(doc, []byte("null")) {
+		return raws
+	}
+	return append(raws, doc)
+}
+
+func decodeRaws(raws []json.RawMessage, v interface{}) error {
+	js, err := json.Marshal(raws)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(js, v)
+}
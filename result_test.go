@@ -0,0 +1,90 @@
+package couchdb_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/cabify/go-couchdb"
+)
+
+func TestViewResult(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_design/test/_view/testview", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, `{
+			"total_rows": 3,
+			"offset": 1,
+			"update_seq": "42-g1AAAA",
+			"rows": [
+				{"id": "a", "key": "a", "value": 1, "doc": {"_id": "a", "field": 1}},
+				{"id": "b", "key": "b", "value": 2, "doc": {"_id": "b", "field": 2}}
+			]
+		}`)
+	})
+
+	var result couchdb.ViewResult
+	err := c.DB("db").View("test", "testview", &result, couchdb.Options{"update_seq": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "result.TotalRows", 3, result.TotalRows)
+	check(t, "result.Offset", 1, result.Offset)
+	check(t, "result.UpdateSeq", "42-g1AAAA", result.UpdateSeq)
+	check(t, "result.Err()", nil, result.Err())
+	check(t, "result.Rows[1].Key", json.RawMessage(`"b"`), result.Rows[1].Key)
+
+	var values []int
+	check(t, "result.DecodeValues()", nil, result.DecodeValues(&values))
+	check(t, "values", []int{1, 2}, values)
+
+	var docs []testDocument
+	check(t, "result.DecodeDocs()", nil, result.DecodeDocs(&docs))
+	check(t, "docs", []testDocument{{ID: "a", Field: 1}, {ID: "b", Field: 2}}, docs)
+}
+
+func TestViewResultRowError(t *testing.T) {
+	result := couchdb.ViewResult{Rows: []couchdb.Row{
+		{Key: json.RawMessage(`"a"`), Value: json.RawMessage(`1`)},
+		{Key: json.RawMessage(`"b"`), Error: "timeout", Reason: "shard timed out"},
+	}}
+	err := result.Err()
+	if rerr, ok := err.(*couchdb.RowError); !ok {
+		t.Fatalf("expected RowError, got %#v", err)
+	} else {
+		check(t, "rerr.ErrorCode", "timeout", rerr.ErrorCode)
+		check(t, "rerr.Key", json.RawMessage(`"b"`), rerr.Key)
+	}
+}
+
+func TestAllDocsResult(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("POST /db/_all_docs", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, `{
+			"total_rows": 2,
+			"offset": 0,
+			"rows": [
+				{"id": "a", "key": "a", "value": {"rev": "1-a"}, "doc": {"_id": "a", "_rev": "1-a", "field": 1}},
+				{"id": "b", "key": "b", "value": {"rev": "2-b", "deleted": true}, "doc": null},
+				{"key": "c", "error": "not_found"}
+			]
+		}`)
+	})
+
+	var result couchdb.AllDocsResult
+	err := c.DB("db").QueryAllDocs(&couchdb.ViewQuery{
+		Keys:        []interface{}{"a", "b", "c"},
+		IncludeDocs: true,
+	}, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "result.Err()", nil, result.Err())
+	check(t, "result.Missing()", []string{"c"}, result.Missing())
+	check(t, "result.Deleted()", []string{"b"}, result.Deleted())
+	check(t, "result.Rows[1].Value.Rev", "2-b", result.Rows[1].Value.Rev)
+
+	var docs []testDocument
+	check(t, "result.DecodeDocs()", nil, result.DecodeDocs(&docs))
+	check(t, "docs", []testDocument{{ID: "a", Rev: "1-a", Field: 1}}, docs)
+}
@@ -0,0 +1,205 @@
+package couchdb
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy configures how a Client retries failed requests.
+// Requests are retried after connection errors and responses with status
+// 429 Too Many Requests or 5xx, which includes 503 Service Unavailable.
+// The zero value uses the defaults noted on each field.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a request is sent, including the
+	// first attempt. Defaults to 3.
+	MaxAttempts int
+
+	// MinBackoff and MaxBackoff limit the time waited between attempts.
+	// The wait starts at MinBackoff and doubles after every attempt up to
+	// MaxBackoff, a random jitter of up to half the wait is subtracted.
+	// They default to 100 milliseconds and 5 seconds.
+	//
+	// If the server sends a Retry-After header, the request is retried
+	// no earlier than requested, or not at all if that is later than
+	// MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// RetryNonIdempotent enables retries for all requests. By default,
+	// only GET and HEAD requests, as well as PUT and DELETE requests for
+	// a specific revision are retried, as sending them twice is harmless.
+	RetryNonIdempotent bool
+}
+
+const (
+	defaultRetryAttempts   = 3
+	defaultRetryMinBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff = 5 * time.Second
+)
+
+// SetRetryPolicy sets the retry policy of the client.
+// Use SetRetryPolicy(nil) to send every request only once, which is the
+// default.
+func (c *Client) SetRetryPolicy(p *RetryPolicy) {
+	c.transport.setRetryPolicy(p)
+}
+
+type noRetryKey struct{}
+
+// WithoutRetry returns a context that disables the retry policy for the
+// requests made with it, e.g.
+//
+//	db.WithContext(couchdb.WithoutRetry(ctx)).Put(id, doc, rev)
+func WithoutRetry(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRetryKey{}, true)
+}
+
+// withDefaults returns a copy of the policy with all defaults applied.
+func (p *RetryPolicy) withDefaults() *RetryPolicy {
+	r := *p
+	if r.MaxAttempts <= 0 {
+		r.MaxAttempts = defaultRetryAttempts
+	}
+	r.MinBackoff, r.MaxBackoff = backoffLimits(r.MinBackoff, r.MaxBackoff,
+		defaultRetryMinBackoff, defaultRetryMaxBackoff)
+	return &r
+}
+
+// backoff returns the time to wait after the given attempt, counted
+// from 1, including jitter.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	return expBackoff(attempt, p.MinBackoff, p.MaxBackoff, 2, true)
+}
+
+// backoffLimits returns min and max with the given defaults applied.
+// A max below min is replaced by the default, or by min if that is
+// still lower.
+func backoffLimits(min, max, defaultMin, defaultMax time.Duration) (time.Duration, time.Duration) {
+	if min <= 0 {
+		min = defaultMin
+	}
+	if max < min {
+		max = defaultMax
+		if max < min {
+			max = min
+		}
+	}
+	return min, max
+}
+
+// expBackoff returns the time to wait after the given attempt, counted
+// from 1. The wait starts at min and is multiplied by factor after every
+// attempt up to max. With jitter, a random duration of up to half the
+// wait is subtracted.
+func expBackoff(attempt int, min, max time.Duration, factor int, jitter bool) time.Duration {
+	d := min
+	for i := 1; i < attempt && d < max; i++ {
+		d *= time.Duration(factor)
+	}
+	if d > max {
+		d = max
+	}
+	if half := int64(d / 2); jitter && half > 0 {
+		d -= time.Duration(rand.Int63n(half))
+	}
+	return d
+}
+
+// allows reports whether the policy permits to retry req at all.
+func (p *RetryPolicy) allows(req *http.Request) bool {
+	if noRetry, _ := req.Context().Value(noRetryKey{}).(bool); noRetry {
+		return false
+	}
+	if p.RetryNonIdempotent {
+		return true
+	}
+	switch req.Method {
+	case "GET", "HEAD":
+		return true
+	case "PUT", "DELETE":
+		return req.URL.Query().Get("rev") != "" || req.Header.Get("If-Match") != ""
+	}
+	return false
+}
+
+// retryDelay returns the time to wait before the next attempt after
+// the given error, or false if the request must not be retried.
+func (p *RetryPolicy) retryDelay(attempt int, err error) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts {
+		return 0, false
+	}
+	d := p.backoff(attempt)
+	dberr, ok := err.(*Error)
+	if !ok {
+		return d, true // connection error
+	}
+	if dberr.StatusCode < 500 && dberr.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	if after, ok := retryAfter(dberr.header); ok {
+		if after > p.MaxBackoff {
+			return 0, false
+		}
+		if after > d {
+			d = after
+		}
+	}
+	return d, true
+}
+
+// retryAfter parses the Retry-After header, which holds either a number
+// of seconds or an HTTP date.
+func retryAfter(header http.Header) (time.Duration, bool) {
+	v := header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if date, err := http.ParseTime(v); err == nil {
+		return time.Until(date), true
+	}
+	return 0, false
+}
+
+// send sends req according to the retry policy of the transport and
+// reports it to the instrumentation.
+// Status codes >= 400 are returned as *Error.
+func (t *transport) send(req *http.Request) (*http.Response, error) {
+	return t.instrument(req, t.sendRetrying)
+}
+
+// sendRetrying sends req according to the retry policy of the transport.
+// Request bodies are replayed with GetBody, requests with bodies that
+// cannot be replayed are sent only once.
+func (t *transport) sendRetrying(req *http.Request) (*http.Response, error) {
+	rt := t.chain()
+	policy := t.getRetryPolicy()
+	if policy == nil || !policy.allows(req) {
+		return t.sendRenewing(rt, req)
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err := t.sendRenewing(rt, req)
+		if err == nil || req.Context().Err() != nil {
+			return resp, err
+		}
+		delay, ok := policy.retryDelay(attempt, err)
+		if !ok {
+			return nil, err
+		}
+		next, rerr := replayRequest(req)
+		if rerr != nil || next == nil {
+			return nil, err
+		}
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		req = next
+	}
+}
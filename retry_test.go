@@ -0,0 +1,149 @@
+package couchdb_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cabify/go-couchdb"
+)
+
+var testRetryPolicy = &couchdb.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond}
+
+func TestRetryServerErrors(t *testing.T) {
+	c := newTestClient(t)
+	c.SetRetryPolicy(testRetryPolicy)
+	attempts := 0
+	c.Handle("GET /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		attempts++
+		switch attempts {
+		case 1:
+			resp.Header().Set("Retry-After", "0")
+			resp.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(resp, `{"error":"unavailable","reason":"maintenance"}`)
+		case 2:
+			resp.WriteHeader(http.StatusTooManyRequests)
+			io.WriteString(resp, `{"error":"too_many_requests","reason":"slow down"}`)
+		default:
+			io.WriteString(resp, `{"_id":"doc","_rev":"1-a","field":1}`)
+		}
+	})
+
+	var doc testDocument
+	if err := c.DB("db").Get("doc", &doc, nil); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "attempts", 3, attempts)
+	check(t, "doc.Field", 1, doc.Field)
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	c := newTestClient(t)
+	c.SetRetryPolicy(testRetryPolicy)
+	attempts := 0
+	c.Handle("GET /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		attempts++
+		resp.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(resp, `{"error":"internal","reason":"broken"}`)
+	})
+
+	err := c.DB("db").Get("doc", nil, nil)
+	check(t, "ErrorStatus(err, 500)", true, couchdb.ErrorStatus(err, http.StatusInternalServerError))
+	check(t, "attempts", 3, attempts)
+}
+
+func TestRetryReplaysBody(t *testing.T) {
+	c := newTestClient(t)
+	c.SetRetryPolicy(testRetryPolicy)
+	var bodies []string
+	c.Handle("PUT /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		check(t, "rev", "1-a", req.URL.Query().Get("rev"))
+		body, _ := ioutil.ReadAll(req.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			resp.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		resp.Header().Set("ETag", `"2-b"`)
+		resp.WriteHeader(http.StatusCreated)
+	})
+
+	rev, err := c.DB("db").Put("doc", &testDocument{Field: 2}, "1-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "rev", "2-b", rev)
+	check(t, "bodies", []string{`{"field":2}`, `{"field":2}`}, bodies)
+}
+
+func TestRetryOnlyIdempotent(t *testing.T) {
+	c := newTestClient(t)
+	c.SetRetryPolicy(testRetryPolicy)
+	attempts := 0
+	unavailable := func(resp http.ResponseWriter, req *http.Request) {
+		attempts++
+		resp.WriteHeader(http.StatusServiceUnavailable)
+	}
+	c.Handle("POST /db/_bulk_docs", unavailable)
+	c.Handle("PUT /db/new", unavailable)
+	c.Handle("GET /db/doc", unavailable)
+
+	db := c.DB("db")
+	if _, err := db.BulkDocs(testDocument{ID: "a"}); err == nil {
+		t.Error("expected an error from BulkDocs")
+	}
+	check(t, "attempts of POST", 1, attempts)
+
+	attempts = 0
+	if _, err := db.Put("new", testDocument{}, ""); err == nil {
+		t.Error("expected an error from Put")
+	}
+	check(t, "attempts of PUT without rev", 1, attempts)
+
+	attempts = 0
+	if err := db.WithContext(couchdb.WithoutRetry(context.Background())).Get("doc", nil, nil); err == nil {
+		t.Error("expected an error from Get")
+	}
+	check(t, "attempts of GET without retry", 1, attempts)
+}
+
+func TestRetryConnectionErrors(t *testing.T) {
+	attempts := 0
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, errors.New("connection reset")
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(http.NoBody),
+			Request:    req,
+		}, nil
+	})
+	c := couchdb.NewClient(asURL("http://127.0.0.1:5984/"), &http.Client{Transport: rt}, nil)
+	c.SetRetryPolicy(testRetryPolicy)
+	if err := c.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	check(t, "attempts", 2, attempts)
+}
+
+func TestRetryAfterTooLate(t *testing.T) {
+	c := newTestClient(t)
+	c.SetRetryPolicy(testRetryPolicy)
+	attempts := 0
+	c.Handle("GET /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		attempts++
+		resp.Header().Set("Retry-After", "3600")
+		resp.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	if err := c.DB("db").Get("doc", nil, nil); err == nil {
+		t.Error("expected an error from Get")
+	}
+	check(t, "attempts", 1, attempts)
+}
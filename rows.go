@@ -0,0 +1,222 @@
+package couchdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Row is a single row of a view or _all_docs result.
+type Row struct {
+	ID    string          `json:"id,omitempty"`
+	Key   json.RawMessage `json:"key"`
+	Value json.RawMessage `json:"value"`
+
+	// The document. This is populated only if the option
+	// "include_docs" is true.
+	Doc json.RawMessage `json:"doc,omitempty"`
+
+	// Error and Reason are set for rows that could not be produced,
+	// e.g. keys that are not found in _all_docs.
+	Error  string `json:"error,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Err returns the error reported for the row, if any.
+func (r *Row) Err() error {
+	if r.Error == "" {
+		return nil
+	}
+	return &RowError{Key: r.Key, ErrorCode: r.Error, Reason: r.Reason}
+}
+
+// DecodeKey unmarshals the key of the row into v.
+func (r *Row) DecodeKey(v interface{}) error {
+	return decodeRaw(r.Key, v)
+}
+
+// DecodeValue unmarshals the value of the row into v.
+func (r *Row) DecodeValue(v interface{}) error {
+	return decodeRaw(r.Value, v)
+}
+
+// DecodeDoc unmarshals the document of the row into v.
+func (r *Row) DecodeDoc(v interface{}) error {
+	return decodeRaw(r.Doc, v)
+}
+
+func decodeRaw(raw json.RawMessage, v interface{}) error {
+	if raw == nil {
+		return errors.New("couchdb: no data to decode")
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// Rows is an iterator over the rows of a view or _all_docs result.
+// Rows are decoded one at a time while they are read from the connection,
+// so the result never has to fit into memory.
+// On each call to the Next method, the embedded Row is updated
+// for the current row. Next is designed to be used in a for loop:
+//
+//	rows, err := db.ViewRows("ddoc", "view", nil)
+//	...
+//	for {
+//		ok, err := rows.Next()
+//		if !ok {
+//			break
+//		}
+//		fmt.Printf("row: %s %s", rows.Key, rows.Value)
+//	}
+//	err = rows.Err()
+//	...
+//
+// Errors reported for single rows don't stop the iteration, they are
+// returned by rows.Row.Err().
+type Rows struct {
+	// TotalRows and Offset are read before the first row. Should CouchDB
+	// send them after the rows, they are set once Next returns false.
+	TotalRows int
+	Offset    int
+	UpdateSeq interface{} // Only set if the option "update_seq" is true
+
+	Row
+
+	end  bool
+	err  error
+	conn io.Closer
+	dec  *json.Decoder
+}
+
+// ViewRows invokes a view like View, but returns an iterator over the
+// rows of the result instead of unmarshalling all of them at once.
+func (db *DB) ViewRows(ddoc, view string, opts QueryOptions) (*Rows, error) {
+	ddoc = strings.Replace(ddoc, "_design/", "", 1)
+	path, err := optpath(opts, viewJsonKeys, db.name, "_design", ddoc, "_view", view)
+	if err != nil {
+		return nil, err
+	}
+	return db.rows(db.viewctx("ViewRows", ddoc, view), "GET", path, nil)
+}
+
+// PostViewRows invokes a view like PostView, but returns an iterator over
+// the rows of the result instead of unmarshalling all of them at once.
+func (db *DB) PostViewRows(ddoc, view string, opts QueryOptions, payload Payload) (*Rows, error) {
+	ddoc = strings.Replace(ddoc, "_design/", "", 1)
+	path, err := optpath(opts, viewJsonKeys, db.name, "_design", ddoc, "_view", view)
+	if err != nil {
+		return nil, err
+	}
+	json, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return db.rows(db.viewctx("PostViewRows", ddoc, view), "POST", path, bytes.NewReader(json))
+}
+
+// AllDocsRows invokes the _all_docs view like AllDocs, but returns an
+// iterator over the rows of the result instead of unmarshalling all of
+// them at once.
+func (db *DB) AllDocsRows(opts QueryOptions) (*Rows, error) {
+	path, err := optpath(opts, viewJsonKeys, db.name, "_all_docs")
+	if err != nil {
+		return nil, err
+	}
+	return db.rows(db.opctx("AllDocsRows"), "GET", path, nil)
+}
+
+func (db *DB) rows(ctx context.Context, method, path string, body io.Reader) (*Rows, error) {
+	resp, err := db.request(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	rows := &Rows{conn: resp.Body, dec: json.NewDecoder(resp.Body)}
+	if err := rows.start(); err != nil {
+		rows.Close()
+		return nil, err
+	}
+	return rows, nil
+}
+
+// start reads the result up to the first row.
+func (r *Rows) start() error {
+	if err := expectDelim(r.dec, '{'); err != nil {
+		return err
+	}
+	inRows, err := r.readFields()
+	if err == nil && !inRows {
+		r.end = true
+	}
+	return err
+}
+
+// readFields reads the fields of the result object until either the rows
+// array has been opened, or the end of the object has been reached.
+func (r *Rows) readFields() (inRows bool, err error) {
+	for r.dec.More() {
+		tok, err := r.dec.Token()
+		if err != nil {
+			return false, err
+		}
+		switch tok {
+		case "rows":
+			return true, expectDelim(r.dec, '[')
+		case "total_rows":
+			err = r.dec.Decode(&r.TotalRows)
+		case "offset":
+			err = r.dec.Decode(&r.Offset)
+		case "update_seq":
+			err = r.dec.Decode(&r.UpdateSeq)
+		default:
+			err = r.dec.Decode(new(json.RawMessage))
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return false, expectDelim(r.dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("couchdb: unexpected %v in result, expected %v", tok, delim)
+	}
+	return nil
+}
+
+// Next decodes the next row. It returns false when the end of the result
+// has been reached or an error has occurred.
+func (r *Rows) Next() (bool, error) {
+	r.Row = Row{}
+	if r.end {
+		return false, r.err
+	}
+	if r.dec.More() {
+		if r.err = r.dec.Decode(&r.Row); r.err == nil {
+			return true, nil
+		}
+	} else if r.err = expectDelim(r.dec, ']'); r.err == nil {
+		_, r.err = r.readFields()
+	}
+	r.Close()
+	return false, r.err
+}
+
+// Err returns the last error that occurred during iteration.
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Close terminates the connection of the iterator.
+// If Next returns false, the iterator has already been closed.
+func (r *Rows) Close() error {
+	r.end = true
+	return r.conn.Close()
+}
@@ -0,0 +1,124 @@
+package couchdb_test
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/cabify/go-couchdb"
+)
+
+func TestViewRows(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_design/test/_view/testview",
+		func(resp http.ResponseWriter, req *http.Request) {
+			check(t, "request query values", url.Values{"include_docs": {"true"}}, req.URL.Query())
+			io.WriteString(resp, `{"total_rows":3,"offset":1,"rows":[
+				{"id":"a","key":["x",1],"value":1,"doc":{"_id":"a","field":1}},
+				{"id":"b","key":["x",2],"value":2,"doc":{"_id":"b","field":2}}
+			]}`)
+		})
+
+	rows, err := c.DB("db").ViewRows("_design/test", "testview", couchdb.Options{"include_docs": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "rows.TotalRows", 3, rows.TotalRows)
+	check(t, "rows.Offset", 1, rows.Offset)
+
+	t.Log("-- first row")
+	ok, err := rows.Next()
+	check(t, "rows.Next()", true, ok)
+	check(t, "rows.Err()", error(nil), err)
+	check(t, "rows.ID", "a", rows.ID)
+	check(t, "rows.Key", json.RawMessage(`["x",1]`), rows.Key)
+	var value int
+	check(t, "rows.DecodeValue()", nil, rows.DecodeValue(&value))
+	check(t, "value", 1, value)
+	var doc testDocument
+	check(t, "rows.DecodeDoc()", nil, rows.DecodeDoc(&doc))
+	check(t, "doc", testDocument{ID: "a", Field: 1}, doc)
+
+	t.Log("-- second row")
+	ok, err = rows.Next()
+	check(t, "rows.Next()", true, ok)
+	check(t, "rows.ID", "b", rows.ID)
+
+	t.Log("-- end of rows")
+	ok, err = rows.Next()
+	check(t, "rows.Next()", false, ok)
+	check(t, "rows.Err()", error(nil), err)
+	check(t, "rows.ID", "", rows.ID)
+}
+
+func TestPostViewRowsReduce(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("POST /db/_design/test/_view/testview",
+		func(resp http.ResponseWriter, req *http.Request) {
+			body, _ := ioutil.ReadAll(req.Body)
+			check(t, "request body", `{"keys":["a"]}`, string(body))
+			io.WriteString(resp, `{"rows":[{"key":"a","value":42}]}`)
+		})
+
+	rows, err := c.DB("db").PostViewRows("test", "testview", couchdb.Options{"group": true},
+		couchdb.Payload{"keys": []string{"a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, _ := rows.Next()
+	check(t, "rows.Next()", true, ok)
+	var key string
+	check(t, "rows.DecodeKey()", nil, rows.DecodeKey(&key))
+	check(t, "key", "a", key)
+	check(t, "rows.Value", json.RawMessage(`42`), rows.Value)
+	ok, err = rows.Next()
+	check(t, "rows.Next()", false, ok)
+	check(t, "rows.Err()", error(nil), err)
+}
+
+func TestAllDocsRowsTrailingFields(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_all_docs", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, `{"rows":[{"id":"a","key":"a","value":{"rev":"1-a"}}],"total_rows":1,"offset":0}`)
+	})
+
+	rows, err := c.DB("db").AllDocsRows(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for {
+		ok, err := rows.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		ids = append(ids, rows.ID)
+	}
+	check(t, "ids", []string{"a"}, ids)
+	check(t, "rows.TotalRows", 1, rows.TotalRows)
+}
+
+func TestRowsTruncated(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_all_docs", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, `{"total_rows":2,"offset":0,"rows":[{"id":"a","key":"a","value":{}},`)
+	})
+
+	rows, err := c.DB("db").AllDocsRows(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, _ := rows.Next()
+	check(t, "rows.Next()", true, ok)
+	ok, err = rows.Next()
+	check(t, "rows.Next()", false, ok)
+	if err == nil {
+		t.Error("expected an error for a truncated result")
+	}
+}
@@ -0,0 +1,289 @@
+package couchdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Change is a single event of a _changes feed, as delivered by a
+// Subscription. The fields have the same meaning as in ChangesFeed.
+type Change struct {
+	ID        string
+	Seq       interface{}
+	Deleted   bool
+	Changes   []ChangeRev
+	Doc       json.RawMessage
+	Rev       string
+	Conflicts []string
+}
+
+// DecodeDoc unmarshals the document of the change into v.
+// The feed option "include_docs" must be true.
+func (c *Change) DecodeDoc(v interface{}) error {
+	return decodeRaw(c.Doc, v)
+}
+
+// SubscribeOptions configures a Subscription.
+type SubscribeOptions struct {
+	// Options of the changes feed. The "since" option is only used if
+	// there is no checkpoint yet.
+	Options QueryOptions
+
+	// Body is sent as JSON payload of a POST request if it is not nil,
+	// e.g. the selector of a feed filtered by _selector.
+	Body interface{}
+
+	// Reconnect configures the underlying resilient feed.
+	Reconnect *ReconnectOptions
+
+	// AutoAck acknowledges every change as soon as it has been received
+	// from the channel, before it has been processed, so a change can be
+	// lost if the process crashes meanwhile. Otherwise changes must be
+	// acknowledged with Ack once they have been processed. AutoAck can't
+	// be combined with Buffer, as buffered changes have not been received.
+	AutoAck bool
+
+	// CommitInterval is the interval in which the last acknowledged
+	// sequence is written to the checkpoint. If it is zero, every Ack,
+	// including those of AutoAck, writes the checkpoint right away.
+	CommitInterval time.Duration
+
+	// Buffer is the capacity of the channel. It must be zero with AutoAck.
+	Buffer int
+}
+
+// Subscription delivers the changes of a database on a channel and keeps
+// track of the processed changes in a _local/<name> checkpoint document.
+// A new Subscription with the same name resumes after the last
+// checkpoint.
+type Subscription struct {
+	// C delivers the changes. It is closed when the subscription ends.
+	C <-chan Change
+
+	db       *DB
+	id       string
+	interval time.Duration
+	autoAck  bool
+
+	mu        sync.Mutex
+	commitMu  sync.Mutex
+	rev       string      // revision of the checkpoint document
+	acked     interface{} // last acknowledged seq
+	committed interface{} // last seq written to the checkpoint
+	acks      int         // number of acks, to detect new ones
+	written   int         // value of acks when committed was written
+	err       error
+}
+
+type checkpoint struct {
+	ID  string      `json:"_id"`
+	Rev string      `json:"_rev,omitempty"`
+	Seq interface{} `json:"seq"`
+}
+
+// Subscribe starts a Subscription to the changes of the database.
+// It reads the checkpoint called name and follows a resilient continuous
+// feed from there until ctx is done or the feed fails for good.
+// The checkpoint is always written with the context of db, so that the
+// final commit succeeds after ctx has been cancelled.
+func (db *DB) Subscribe(ctx context.Context, name string, opts *SubscribeOptions) (*Subscription, error) {
+	if name == "" {
+		return nil, errors.New("couchdb.Subscribe: empty name")
+	}
+	if opts == nil {
+		opts = new(SubscribeOptions)
+	}
+	if opts.AutoAck && opts.Buffer > 0 {
+		return nil, errors.New("couchdb.Subscribe: AutoAck can't be used with a Buffer")
+	}
+	s := &Subscription{
+		db:       db,
+		id:       "_local/" + name,
+		interval: opts.CommitInterval,
+		autoAck:  opts.AutoAck,
+	}
+
+	var cp checkpoint
+	if err := s.readCheckpoint(&cp); err != nil && !NotFound(err) {
+		return nil, err
+	}
+	s.rev, s.acked, s.committed = cp.Rev, cp.Seq, cp.Seq
+
+	feedOpts := opts.Options
+	if cp.Seq != nil {
+		feedOpts = withOptions(feedOpts, Option{"since", cp.Seq})
+	}
+	var (
+		feed *ChangesFeed
+		err  error
+	)
+	if opts.Body != nil {
+		feed, err = db.WithContext(ctx).ResilientChangesWithBody(feedOpts, opts.Body, opts.Reconnect)
+	} else {
+		feed, err = db.WithContext(ctx).ResilientChanges(feedOpts, opts.Reconnect)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Change, opts.Buffer)
+	s.C = ch
+	go s.run(ctx, feed, ch)
+	return s, nil
+}
+
+func (s *Subscription) run(ctx context.Context, feed *ChangesFeed, ch chan<- Change) {
+	defer close(ch)
+	stop := make(chan struct{})
+	defer close(stop)
+	if s.interval > 0 {
+		go s.commitLoop(stop)
+	}
+
+	var err error
+loop:
+	for {
+		var ok bool
+		if ok, err = feed.Next(); !ok {
+			break
+		}
+		c := Change{
+			ID:        feed.ID,
+			Seq:       feed.Seq,
+			Deleted:   feed.Deleted,
+			Changes:   feed.Changes,
+			Doc:       feed.Doc,
+			Rev:       feed.Rev,
+			Conflicts: feed.Conflicts,
+		}
+		select {
+		case ch <- c:
+			if s.autoAck {
+				if err := s.Ack(c); err != nil {
+					s.mu.Lock()
+					s.err = err
+					s.mu.Unlock()
+				}
+			}
+		case <-ctx.Done():
+			err = ctx.Err()
+			break loop
+		}
+	}
+	feed.Close()
+	if err == nil {
+		err = ctx.Err()
+	}
+	if s.interval > 0 || s.autoAck {
+		if cerr := s.Commit(); err == nil {
+			err = cerr
+		}
+	}
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+func (s *Subscription) commitLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Commit(); err != nil {
+				s.mu.Lock()
+				s.err = err
+				s.mu.Unlock()
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Ack marks the change and all changes delivered before it as processed.
+// Without a CommitInterval, the checkpoint is written right away.
+func (s *Subscription) Ack(c Change) error {
+	s.ack(c.Seq)
+	if s.interval > 0 {
+		return nil
+	}
+	return s.Commit()
+}
+
+func (s *Subscription) ack(seq interface{}) {
+	s.mu.Lock()
+	s.acked = seq
+	s.acks++
+	s.mu.Unlock()
+}
+
+// Commit writes the last acknowledged sequence to the checkpoint, unless
+// it has been written already.
+func (s *Subscription) Commit() error {
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+	s.mu.Lock()
+	cp := checkpoint{ID: s.id, Rev: s.rev, Seq: s.acked}
+	acks := s.acks
+	s.mu.Unlock()
+	if acks == s.written {
+		return nil
+	}
+
+	rev, err := s.writeCheckpoint(&cp)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.rev, s.committed, s.written = rev, cp.Seq, acks
+	s.mu.Unlock()
+	return nil
+}
+
+// Checkpoint returns the last sequence written to the checkpoint.
+func (s *Subscription) Checkpoint() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.committed
+}
+
+// Err returns the error that ended the subscription, or the last error
+// of a scheduled commit. It is only final once C has been closed.
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// path returns the path of the checkpoint document.
+func (s *Subscription) path() string {
+	return path(s.db.name, "_local", strings.TrimPrefix(s.id, "_local/"))
+}
+
+func (s *Subscription) readCheckpoint(cp *checkpoint) error {
+	resp, err := s.db.request(s.db.opctx("Subscribe"), "GET", s.path(), nil)
+	if err != nil {
+		return err
+	}
+	return readBody(resp, cp)
+}
+
+func (s *Subscription) writeCheckpoint(cp *checkpoint) (string, error) {
+	json, err := json.Marshal(cp)
+	if err != nil {
+		return "", err
+	}
+	body := bytes.NewReader(json)
+	resp, err := s.db.request(s.db.opctx("Commit"), "PUT", s.path(), body)
+	if err != nil {
+		return "", err
+	}
+	_, rev, err := responseIDRev(resp)
+	return rev, err
+}
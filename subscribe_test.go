@@ -0,0 +1,175 @@
+package couchdb_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cabify/go-couchdb"
+)
+
+func TestSubscribeResumesAndAcks(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_local/indexer", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, `{"_id":"_local/indexer","_rev":"0-1","seq":"1-a"}`)
+	})
+	c.Handle("GET /db/_changes", func(resp http.ResponseWriter, req *http.Request) {
+		check(t, "since", "1-a", req.URL.Query().Get("since"))
+		io.WriteString(resp, `{"seq":"2-b","id":"doc2","changes":[{"rev":"1-b"}]}`+"\n")
+		io.WriteString(resp, `{"seq":"3-c","id":"doc3","changes":[{"rev":"1-c"}]}`+"\n")
+		io.WriteString(resp, `{"last_seq":"3-c"}`+"\n")
+	})
+	var (
+		mu   sync.Mutex
+		puts []string
+	)
+	c.Handle("PUT /db/_local/indexer", func(resp http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		mu.Lock()
+		puts = append(puts, string(body))
+		rev := len(puts) + 1
+		mu.Unlock()
+		resp.WriteHeader(http.StatusCreated)
+		io.WriteString(resp, `{"ok":true,"id":"_local/indexer","rev":"0-`+strconv.Itoa(rev)+`"}`)
+	})
+
+	sub, err := c.DB("db").Subscribe(context.Background(), "indexer", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "sub.Checkpoint()", "1-a", sub.Checkpoint())
+	var ids []string
+	for change := range sub.C {
+		ids = append(ids, change.ID)
+		if err := sub.Ack(change); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(t, "sub.Err()", error(nil), sub.Err())
+	check(t, "ids", []string{"doc2", "doc3"}, ids)
+	check(t, "checkpoint writes", []string{
+		`{"_id":"_local/indexer","_rev":"0-1","seq":"2-b"}`,
+		`{"_id":"_local/indexer","_rev":"0-2","seq":"3-c"}`,
+	}, puts)
+	check(t, "sub.Checkpoint()", "3-c", sub.Checkpoint())
+}
+
+func TestSubscribeAutoAckCommitInterval(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_local/sync", func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusNotFound)
+		io.WriteString(resp, `{"error":"not_found","reason":"missing"}`)
+	})
+	c.Handle("GET /db/_changes", func(resp http.ResponseWriter, req *http.Request) {
+		check(t, "since", "now", req.URL.Query().Get("since"))
+		check(t, "include_docs", "true", req.URL.Query().Get("include_docs"))
+		io.WriteString(resp, `{"seq":"5-e","id":"doc","changes":[{"rev":"2-e"}],"doc":{"_id":"doc","_rev":"2-e","field":5}}`+"\n")
+		io.WriteString(resp, `{"last_seq":"5-e"}`+"\n")
+	})
+	var puts []string
+	c.Handle("PUT /db/_local/sync", func(resp http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		puts = append(puts, string(body))
+		resp.WriteHeader(http.StatusCreated)
+		io.WriteString(resp, `{"ok":true,"id":"_local/sync","rev":"0-1"}`)
+	})
+
+	sub, err := c.DB("db").Subscribe(context.Background(), "sync", &couchdb.SubscribeOptions{
+		Options:        couchdb.Options{"since": "now", "include_docs": true},
+		AutoAck:        true,
+		CommitInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var docs []testDocument
+	for change := range sub.C {
+		var doc testDocument
+		if err := change.DecodeDoc(&doc); err != nil {
+			t.Fatal(err)
+		}
+		check(t, "change.Rev", "2-e", change.Rev)
+		docs = append(docs, doc)
+	}
+	check(t, "sub.Err()", error(nil), sub.Err())
+	check(t, "docs", []testDocument{{ID: "doc", Rev: "2-e", Field: 5}}, docs)
+	check(t, "checkpoint writes", []string{`{"_id":"_local/sync","seq":"5-e"}`}, puts)
+}
+
+func TestSubscribeCancel(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_local/sub", func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusNotFound)
+	})
+	c.Handle("GET /db/_changes", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, `{"seq":"1-a","id":"doc","changes":[{"rev":"1-a"}]}`+"\n")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, err := c.DB("db").Subscribe(ctx, "sub", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	change := <-sub.C
+	check(t, "change.ID", "doc", change.ID)
+	cancel()
+	for range sub.C {
+	}
+	check(t, "sub.Err()", context.Canceled, sub.Err())
+	check(t, "sub.Checkpoint()", nil, sub.Checkpoint())
+}
+
+func TestSubscribeAutoAckCommitsEachChange(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_local/sync", func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusNotFound)
+		io.WriteString(resp, `{"error":"not_found","reason":"missing"}`)
+	})
+	c.Handle("GET /db/_changes", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, `{"seq":"1-a","id":"doc1","changes":[{"rev":"1-a"}]}`+"\n")
+		io.WriteString(resp, `{"seq":"2-b","id":"doc2","changes":[{"rev":"1-b"}]}`+"\n")
+		io.WriteString(resp, `{"last_seq":"2-b"}`+"\n")
+	})
+	var (
+		mu   sync.Mutex
+		puts []string
+	)
+	c.Handle("PUT /db/_local/sync", func(resp http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		mu.Lock()
+		puts = append(puts, string(body))
+		rev := len(puts)
+		mu.Unlock()
+		resp.WriteHeader(http.StatusCreated)
+		io.WriteString(resp, `{"ok":true,"id":"_local/sync","rev":"0-`+strconv.Itoa(rev)+`"}`)
+	})
+
+	sub, err := c.DB("db").Subscribe(context.Background(), "sync", &couchdb.SubscribeOptions{AutoAck: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var writes []int
+	for range sub.C {
+		mu.Lock()
+		writes = append(writes, len(puts))
+		mu.Unlock()
+	}
+	check(t, "sub.Err()", error(nil), sub.Err())
+	// The first change is committed before the second one is delivered.
+	check(t, "checkpoint writes before the second change", 1, writes[1])
+	check(t, "checkpoint writes", []string{
+		`{"_id":"_local/sync","seq":"1-a"}`,
+		`{"_id":"_local/sync","_rev":"0-1","seq":"2-b"}`,
+	}, puts)
+}
+
+func TestSubscribeAutoAckWithBuffer(t *testing.T) {
+	c := newTestClient(t)
+	_, err := c.DB("db").Subscribe(context.Background(), "sync", &couchdb.SubscribeOptions{AutoAck: true, Buffer: 10})
+	check(t, "error", "couchdb.Subscribe: AutoAck can't be used with a Buffer", err.Error())
+}
@@ -0,0 +1,93 @@
+package couchdb
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"time"
+)
+
+// ErrDelete is returned by the function passed to Update to delete the
+// document instead of writing it.
+var ErrDelete = errors.New("couchdb: delete document")
+
+// updateRetry limits the attempts of Update and ResolveConflicts when the
+// document is changed concurrently.
+var updateRetry = (&RetryPolicy{
+	MaxAttempts: 5,
+	MinBackoff:  10 * time.Millisecond,
+	MaxBackoff:  500 * time.Millisecond,
+}).withDefaults()
+
+// Update reads a document, modifies it and writes it back. The document
+// is decoded into doc, which must be a non-nil pointer, and fn is called
+// with doc to change it. The result is then stored with Put at the
+// revision that was read, so fields like _id and _rev don't have to be
+// kept in doc.
+//
+// If the document doesn't exist, doc is reset to its zero value and
+// stored as a new document. If fn returns ErrDelete, the document is
+// deleted, or left alone if it doesn't exist. Any other error of fn
+// aborts the update and is returned as is.
+//
+// When the write fails with a conflict because the document was changed
+// in the meantime, Update waits a bit and starts over: doc is reset, the
+// document is read again and fn is called again with it, so fn should
+// have no other side effects. After 5 attempts, the conflict error is
+// returned.
+//
+// The new revision is returned, or an empty string if fn asked to delete
+// a document that doesn't exist.
+func (db *DB) Update(id string, doc interface{}, fn func(doc interface{}) error) (rev string, err error) {
+	v := reflect.ValueOf(doc)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return "", errors.New("couchdb.Update: doc must be a non-nil pointer")
+	}
+	for attempt := 1; ; attempt++ {
+		rev, err = db.update(id, doc, fn)
+		if !Conflict(err) || attempt >= updateRetry.MaxAttempts {
+			return rev, err
+		}
+		select {
+		case <-time.After(updateRetry.backoff(attempt)):
+		case <-db.ctx.Done():
+			return "", db.ctx.Err()
+		}
+	}
+}
+
+// update makes a single attempt of Update.
+func (db *DB) update(id string, doc interface{}, fn func(doc interface{}) error) (string, error) {
+	v := reflect.ValueOf(doc).Elem()
+	v.Set(reflect.Zero(v.Type()))
+	var (
+		raw     json.RawMessage
+		current struct {
+			Rev string `json:"_rev"`
+		}
+	)
+	err := db.Get(id, &raw, nil)
+	switch {
+	case NotFound(err):
+	case err != nil:
+		return "", err
+	default:
+		if err := json.Unmarshal(raw, doc); err != nil {
+			return "", err
+		}
+		if err := json.Unmarshal(raw, &current); err != nil {
+			return "", err
+		}
+	}
+
+	switch err := fn(doc); {
+	case err == ErrDelete:
+		if current.Rev == "" {
+			return "", nil
+		}
+		return db.Delete(id, current.Rev)
+	case err != nil:
+		return "", err
+	}
+	return db.Put(id, doc, current.Rev)
+}
@@ -0,0 +1,145 @@
+package couchdb_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/cabify/go-couchdb"
+)
+
+func TestUpdateRetriesConflicts(t *testing.T) {
+	c := newTestClient(t)
+	var gets, puts int
+	c.Handle("GET /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		gets++
+		if gets == 1 {
+			io.WriteString(resp, `{"_id":"doc","_rev":"1-a","field":1}`)
+		} else {
+			io.WriteString(resp, `{"_id":"doc","_rev":"2-b","field":10}`)
+		}
+	})
+	c.Handle("PUT /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		puts++
+		body, _ := ioutil.ReadAll(req.Body)
+		if puts == 1 {
+			check(t, "rev of first put", "1-a", req.URL.Query().Get("rev"))
+			check(t, "body of first put", `{"_id":"doc","_rev":"1-a","field":2}`, string(body))
+			resp.WriteHeader(http.StatusConflict)
+			io.WriteString(resp, `{"error":"conflict","reason":"Document update conflict."}`)
+			return
+		}
+		check(t, "rev of second put", "2-b", req.URL.Query().Get("rev"))
+		check(t, "body of second put", `{"_id":"doc","_rev":"2-b","field":11}`, string(body))
+		resp.Header().Set("ETag", `"3-c"`)
+		resp.WriteHeader(http.StatusCreated)
+		io.WriteString(resp, `{"ok":true,"id":"doc","rev":"3-c"}`)
+	})
+
+	var doc testDocument
+	rev, err := c.DB("db").Update("doc", &doc, func(v interface{}) error {
+		v.(*testDocument).Field++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "rev", "3-c", rev)
+	check(t, "doc", testDocument{ID: "doc", Rev: "2-b", Field: 11}, doc)
+	check(t, "number of gets", 2, gets)
+}
+
+func TestUpdateGivesUp(t *testing.T) {
+	c := newTestClient(t)
+	var puts int
+	c.Handle("GET /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, `{"_id":"doc","_rev":"1-a"}`)
+	})
+	c.Handle("PUT /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		puts++
+		resp.WriteHeader(http.StatusConflict)
+		io.WriteString(resp, `{"error":"conflict","reason":"Document update conflict."}`)
+	})
+
+	_, err := c.DB("db").Update("doc", new(testDocument), func(interface{}) error { return nil })
+	check(t, "Conflict(err)", true, couchdb.Conflict(err))
+	check(t, "number of puts", 5, puts)
+}
+
+func TestUpdateCreates(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusNotFound)
+		io.WriteString(resp, `{"error":"not_found","reason":"missing"}`)
+	})
+	c.Handle("PUT /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		check(t, "rev", "", req.URL.Query().Get("rev"))
+		check(t, "body", `{"field":7}`, string(body))
+		resp.Header().Set("ETag", `"1-a"`)
+		resp.WriteHeader(http.StatusCreated)
+		io.WriteString(resp, `{"ok":true,"id":"doc","rev":"1-a"}`)
+	})
+
+	doc := map[string]interface{}{"stale": true}
+	rev, err := c.DB("db").Update("doc", &doc, func(v interface{}) error {
+		m := v.(*map[string]interface{})
+		check(t, "doc passed to fn", map[string]interface{}(nil), *m)
+		*m = map[string]interface{}{"field": 7}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "rev", "1-a", rev)
+}
+
+func TestUpdateDeletes(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, `{"_id":"doc","_rev":"1-a","field":1}`)
+	})
+	c.Handle("DELETE /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		check(t, "rev", "1-a", req.URL.Query().Get("rev"))
+		resp.Header().Set("ETag", `"2-b"`)
+		resp.WriteHeader(http.StatusOK)
+		io.WriteString(resp, `{"ok":true,"id":"doc","rev":"2-b"}`)
+	})
+
+	rev, err := c.DB("db").Update("doc", new(testDocument), func(interface{}) error {
+		return couchdb.ErrDelete
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "rev", "2-b", rev)
+
+	c.Handle("GET /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusNotFound)
+		io.WriteString(resp, `{"error":"not_found","reason":"deleted"}`)
+	})
+	c.Handle("DELETE /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		t.Error("deleted a missing document")
+	})
+	rev, err = c.DB("db").Update("doc", new(testDocument), func(interface{}) error {
+		return couchdb.ErrDelete
+	})
+	check(t, "rev of missing document", "", rev)
+	check(t, "error for missing document", nil, err)
+}
+
+func TestUpdateReturnsErrors(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/doc", func(resp http.ResponseWriter, req *http.Request) {
+		io.WriteString(resp, `{"_id":"doc","_rev":"1-a"}`)
+	})
+
+	failed := errors.New("failed")
+	_, err := c.DB("db").Update("doc", new(testDocument), func(interface{}) error { return failed })
+	check(t, "error of fn", failed, err)
+
+	_, err = c.DB("db").Update("doc", testDocument{}, func(interface{}) error { return nil })
+	check(t, "error for non-pointer", "couchdb.Update: doc must be a non-nil pointer", err.Error())
+}
@@ -0,0 +1,242 @@
+package couchdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ViewQuery holds the typed parameters of a view or _all_docs query.
+// Unset fields are not sent, so CouchDB applies its defaults. Keys are
+// encoded as JSON, nil keys are left out.
+//
+// http://docs.couchdb.org/en/latest/api/ddoc/views.html#db-design-design-doc-view-view-name
+type ViewQuery struct {
+	Key           interface{}
+	Keys          []interface{}
+	StartKey      interface{}
+	EndKey        interface{}
+	StartKeyDocID string
+	EndKeyDocID   string
+	InclusiveEnd  *bool
+
+	Limit      int // Zero means no limit
+	Skip       int
+	Descending bool
+
+	Reduce     *bool
+	Group      bool
+	GroupLevel int
+
+	IncludeDocs bool
+	Conflicts   bool // Only valid together with IncludeDocs
+
+	Stale     string // "ok" | "update_after", deprecated in CouchDB 2.x
+	Update    string // "true" | "false" | "lazy"
+	Stable    bool
+	UpdateSeq bool
+	Sorted    *bool
+}
+
+func invalidQuery(format string, args ...interface{}) error {
+	return fmt.Errorf("couchdb: invalid view query: "+format, args...)
+}
+
+// Validate checks the query for invalid values and combinations
+// of parameters that CouchDB would reject.
+func (q *ViewQuery) Validate() error {
+	switch {
+	case q.Limit < 0:
+		return invalidQuery("negative limit %d", q.Limit)
+	case q.Skip < 0:
+		return invalidQuery("negative skip %d", q.Skip)
+	case q.GroupLevel < 0:
+		return invalidQuery("negative group_level %d", q.GroupLevel)
+	case q.Key != nil && q.Keys != nil:
+		return invalidQuery("key and keys are mutually exclusive")
+	case q.Keys != nil && (q.StartKey != nil || q.EndKey != nil):
+		return invalidQuery("keys cannot be combined with startkey or endkey")
+	case (q.Group || q.GroupLevel > 0) && q.Reduce != nil && !*q.Reduce:
+		return invalidQuery("group and group_level require reduce")
+	case (q.Group || q.GroupLevel > 0) && q.IncludeDocs:
+		return invalidQuery("include_docs is invalid for grouped queries")
+	case q.Conflicts && !q.IncludeDocs:
+		return invalidQuery("conflicts requires include_docs")
+	case q.Stale != "" && (q.Stable || q.Update != ""):
+		return invalidQuery("stale cannot be combined with stable or update")
+	}
+	switch q.Stale {
+	case "", "ok", "update_after":
+	default:
+		return invalidQuery("unknown stale value %q", q.Stale)
+	}
+	switch q.Update {
+	case "", "true", "false", "lazy":
+	default:
+		return invalidQuery("unknown update value %q", q.Update)
+	}
+	return nil
+}
+
+// Options validates the query and converts it into the equivalent
+// Options, as accepted by View, AllDocs and friends.
+func (q *ViewQuery) Options() (Options, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	opts := make(Options)
+	setKey := func(k string, v interface{}) {
+		if v != nil {
+			opts[k] = v
+		}
+	}
+	setKey("key", q.Key)
+	if q.Keys != nil {
+		opts["keys"] = q.Keys
+	}
+	setKey("startkey", q.StartKey)
+	setKey("endkey", q.EndKey)
+	if q.StartKeyDocID != "" {
+		opts["startkey_docid"] = q.StartKeyDocID
+	}
+	if q.EndKeyDocID != "" {
+		opts["endkey_docid"] = q.EndKeyDocID
+	}
+	if q.InclusiveEnd != nil {
+		opts["inclusive_end"] = *q.InclusiveEnd
+	}
+	if q.Limit > 0 {
+		opts["limit"] = q.Limit
+	}
+	if q.Skip > 0 {
+		opts["skip"] = q.Skip
+	}
+	if q.Descending {
+		opts["descending"] = true
+	}
+	if q.Reduce != nil {
+		opts["reduce"] = *q.Reduce
+	}
+	if q.Group {
+		opts["group"] = true
+	}
+	if q.GroupLevel > 0 {
+		opts["group_level"] = q.GroupLevel
+	}
+	if q.IncludeDocs {
+		opts["include_docs"] = true
+	}
+	if q.Conflicts {
+		opts["conflicts"] = true
+	}
+	if q.Stale != "" {
+		opts["stale"] = q.Stale
+	}
+	if q.Update != "" {
+		opts["update"] = q.Update
+	}
+	if q.Stable {
+		opts["stable"] = true
+	}
+	if q.UpdateSeq {
+		opts["update_seq"] = true
+	}
+	if q.Sorted != nil {
+		opts["sorted"] = *q.Sorted
+	}
+	return opts, nil
+}
+
+// split separates the keys of a query from the rest of its options,
+// so that they can be sent in the body of a POST request.
+func (q *ViewQuery) split() (Options, Payload, error) {
+	opts, err := q.Options()
+	if err != nil || q.Keys == nil {
+		return opts, nil, err
+	}
+	delete(opts, "keys")
+	return opts, Payload{"keys": q.Keys}, nil
+}
+
+// QueryView invokes a view with typed query parameters.
+// The ddoc parameter must be the name of the design document
+// containing the view, but excluding the _design/ prefix.
+//
+// Queries with Keys are sent with PostView, all others with View.
+// The output of the query is unmarshalled into the given result.
+func (db *DB) QueryView(ddoc, view string, q *ViewQuery, result interface{}) error {
+	opts, payload, err := q.split()
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		return db.PostView(ddoc, view, result, opts, payload)
+	}
+	return db.View(ddoc, view, result, opts)
+}
+
+// QueryAllDocs invokes the _all_docs view with typed query parameters.
+// Queries with Keys are sent as POST request.
+// The output of the query is unmarshalled into the given result.
+func (db *DB) QueryAllDocs(q *ViewQuery, result interface{}) error {
+	opts, payload, err := q.split()
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		return db.PostAllDocs(result, opts, payload)
+	}
+	return db.AllDocs(result, opts)
+}
+
+// ViewQueries runs several queries against a view in a single request.
+// The ddoc parameter must be the name of the design document
+// containing the view, but excluding the _design/ prefix.
+// One result is returned per query, in the same order.
+//
+// http://docs.couchdb.org/en/latest/api/ddoc/views.html#sending-multiple-queries-to-a-view
+func (db *DB) ViewQueries(ddoc, view string, queries []ViewQuery) ([]ViewResult, error) {
+	ddoc = strings.Replace(ddoc, "_design/", "", 1)
+	var results []ViewResult
+	err := db.queries(db.viewctx("ViewQueries", ddoc, view),
+		path(db.name, "_design", ddoc, "_view", view, "queries"), queries, &results)
+	return results, err
+}
+
+// AllDocsQueries runs several queries against the _all_docs view in a
+// single request. One result is returned per query, in the same order.
+func (db *DB) AllDocsQueries(queries []ViewQuery) ([]AllDocsResult, error) {
+	var results []AllDocsResult
+	err := db.queries(db.opctx("AllDocsQueries"), path(db.name, "_all_docs", "queries"), queries, &results)
+	return results, err
+}
+
+func (db *DB) queries(ctx context.Context, path string, queries []ViewQuery, results interface{}) error {
+	req := struct {
+		Queries []Options `json:"queries"`
+	}{make([]Options, len(queries))}
+	for i := range queries {
+		opts, err := queries[i].Options()
+		if err != nil {
+			return fmt.Errorf("query %d: %v", i, err)
+		}
+		req.Queries[i] = opts
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := db.request(ctx, "POST", path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	var res struct {
+		Results json.RawMessage `json:"results"`
+	}
+	if err := readBody(resp, &res); err != nil {
+		return err
+	}
+	return json.Unmarshal(res.Results, results)
+}
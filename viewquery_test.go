@@ -0,0 +1,174 @@
+package couchdb_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/cabify/go-couchdb"
+)
+
+func TestViewQueryOptions(t *testing.T) {
+	inclusiveEnd := false
+	q := &couchdb.ViewQuery{
+		StartKey:     []interface{}{"a"},
+		EndKey:       []interface{}{"a", map[string]interface{}{}},
+		InclusiveEnd: &inclusiveEnd,
+		Limit:        10,
+		Descending:   true,
+		IncludeDocs:  true,
+		Update:       "lazy",
+	}
+	opts, err := q.Options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "opts", couchdb.Options{
+		"startkey":      []interface{}{"a"},
+		"endkey":        []interface{}{"a", map[string]interface{}{}},
+		"inclusive_end": false,
+		"limit":         10,
+		"descending":    true,
+		"include_docs":  true,
+		"update":        "lazy",
+	}, opts)
+}
+
+func TestViewQueryValidate(t *testing.T) {
+	noReduce := false
+	tests := []couchdb.ViewQuery{
+		{Limit: -1},
+		{Skip: -1},
+		{Key: "a", Keys: []interface{}{"b"}},
+		{Keys: []interface{}{"a"}, StartKey: "a"},
+		{Group: true, Reduce: &noReduce},
+		{GroupLevel: 2, Reduce: &noReduce},
+		{Group: true, IncludeDocs: true},
+		{Conflicts: true},
+		{Stale: "ok", Update: "false"},
+		{Stale: "later"},
+		{Update: "yes"},
+	}
+	for i, q := range tests {
+		if err := q.Validate(); err == nil {
+			t.Errorf("test %d: expected %+v to be invalid", i, q)
+		}
+	}
+}
+
+func TestQueryView(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("GET /db/_design/test/_view/testview", func(resp http.ResponseWriter, req *http.Request) {
+		expected := url.Values{
+			"group_level": {"2"},
+			"startkey":    {`["a"]`},
+		}
+		check(t, "request query values", expected, req.URL.Query())
+		io.WriteString(resp, `{"rows":[]}`)
+	})
+	c.Handle("POST /db/_design/test/_view/testview", func(resp http.ResponseWriter, req *http.Request) {
+		check(t, "request query values", url.Values{"include_docs": {"true"}}, req.URL.Query())
+		body, _ := ioutil.ReadAll(req.Body)
+		check(t, "request body", `{"keys":["a","b"]}`, string(body))
+		io.WriteString(resp, `{"rows":[]}`)
+	})
+
+	var result struct{ Rows []interface{} }
+	err := c.DB("db").QueryView("_design/test", "testview", &couchdb.ViewQuery{
+		StartKey:   []string{"a"},
+		GroupLevel: 2,
+	}, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.DB("db").QueryView("test", "testview", &couchdb.ViewQuery{
+		Keys:        []interface{}{"a", "b"},
+		IncludeDocs: true,
+	}, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.DB("db").QueryView("test", "testview", &couchdb.ViewQuery{Limit: -1}, &result)
+	if err == nil {
+		t.Error("expected invalid query to fail before sending it")
+	}
+}
+
+func TestQueryAllDocs(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("POST /db/_all_docs", func(resp http.ResponseWriter, req *http.Request) {
+		check(t, "request query string", "", req.URL.RawQuery)
+		body, _ := ioutil.ReadAll(req.Body)
+		check(t, "request body", `{"keys":["a"]}`, string(body))
+		io.WriteString(resp, `{"total_rows":1,"offset":0,"rows":[{"id":"a","key":"a","value":{"rev":"1-a"}}]}`)
+	})
+
+	var result struct {
+		TotalRows int `json:"total_rows"`
+	}
+	err := c.DB("db").QueryAllDocs(&couchdb.ViewQuery{Keys: []interface{}{"a"}}, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "result.TotalRows", 1, result.TotalRows)
+}
+
+func TestViewQueries(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("POST /db/_design/test/_view/testview/queries", func(resp http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		check(t, "request body",
+			`{"queries":[{"endkey":"b","startkey":"a"},{"keys":["x","y"],"limit":1}]}`,
+			string(body))
+		io.WriteString(resp, `{"results":[
+			{"total_rows":3,"offset":0,"rows":[{"id":"a","key":"a","value":1}]},
+			{"total_rows":3,"offset":2,"rows":[{"id":"x","key":"x","value":3}]}
+		]}`)
+	})
+
+	results, err := c.DB("db").ViewQueries("_design/test", "testview", []couchdb.ViewQuery{
+		{StartKey: "a", EndKey: "b"},
+		{Keys: []interface{}{"x", "y"}, Limit: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "len(results)", 2, len(results))
+	check(t, "results[0].Rows[0].ID", "a", results[0].Rows[0].ID)
+	check(t, "results[1].Offset", 2, results[1].Offset)
+	check(t, "results[1].Rows[0].ID", "x", results[1].Rows[0].ID)
+}
+
+func TestAllDocsQueries(t *testing.T) {
+	c := newTestClient(t)
+	c.Handle("POST /db/_all_docs/queries", func(resp http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		check(t, "request body", `{"queries":[{"keys":["a","z"]},{"limit":1,"skip":1}]}`, string(body))
+		io.WriteString(resp, `{"results":[
+			{"total_rows":2,"offset":null,"rows":[{"id":"a","key":"a","value":{"rev":"1-a"}},{"key":"z","error":"not_found"}]},
+			{"total_rows":2,"offset":1,"rows":[{"id":"b","key":"b","value":{"rev":"1-b"}}]}
+		]}`)
+	})
+
+	results, err := c.DB("db").AllDocsQueries([]couchdb.ViewQuery{
+		{Keys: []interface{}{"a", "z"}},
+		{Limit: 1, Skip: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, "len(results)", 2, len(results))
+	check(t, "results[0].Missing()", []string{"z"}, results[0].Missing())
+	check(t, "results[1].Rows[0].Value.Rev", "1-b", results[1].Rows[0].Value.Rev)
+}
+
+func TestViewQueriesInvalid(t *testing.T) {
+	c := newTestClient(t)
+	_, err := c.DB("db").ViewQueries("test", "testview", []couchdb.ViewQuery{{}, {Skip: -1}})
+	if err == nil {
+		t.Error("expected invalid query to fail before sending it")
+	}
+}